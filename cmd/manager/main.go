@@ -29,8 +29,12 @@ func main() {
 		panic("failed to connect database")
 	}
 
-	g := factsv2.New(conf.GetString("pitaya.group.name.uuid"), db)
-	pitaya.Register(g,
+	rooms := factsv2.NewRooms(db, factsv2.Expiry{
+		Idle:    conf.GetDuration("game.room.idle"),
+		Hard:    conf.GetDuration("game.room.expiry"),
+		Warning: conf.GetDuration("game.room.warning"),
+	}, nil)
+	pitaya.Register(rooms,
 		component.WithName("game"),
 		component.WithNameFunc(strings.ToLower),
 	)
@@ -48,6 +52,8 @@ func configApp() *viper.Viper {
 	conf.Set("pitaya.heartbeat.interval", "15s")
 	conf.Set("pitaya.buffer.agent.messages", 32)
 	conf.Set("pitaya.handler.messages.compression", false)
-	conf.SetDefault("pitaya.group.name.uuid", "game")
+	conf.SetDefault("game.room.idle", "15m")
+	conf.SetDefault("game.room.expiry", "4h")
+	conf.SetDefault("game.room.warning", "1m")
 	return conf
 }
@@ -11,10 +11,26 @@ import (
 	"strings"
 )
 
-var config Config
+// seeds lists the files of the question sets of every language
+var seeds = []Seed{
+	{LangCode: "ru", Files: []string{"questions_ru.json", "questions_about_ru.json"}},
+	{LangCode: "en", Files: []string{"questions.json"}},
+}
+
+// Seed is the question set of a single language
+type Seed struct {
+	LangCode string
+	Files    []string
+}
 
 type Config struct {
 	Normals []Normal `json:"normal"`
+	Finals  []Normal `json:"final"`
+	About   []About  `json:"about"`
+}
+type About struct {
+	Question string `json:"question"`
+	Explicit bool   `json:"explicit"`
 }
 type Normal struct {
 	Category           string   `json:"category"`
@@ -22,15 +38,22 @@ type Normal struct {
 	Answer             string   `json:"answer"`
 	AlternateSpellings []string `json:"alternateSpellings"`
 	Suggestions        []string `json:"suggestions"`
+	Explicit           bool     `json:"explicit"`
 }
 
-func init() {
-	filepaths, _ := filepath.Glob(filepath.Join("questions_ru.json"))
+func loadConfig(files []string) Config {
+	var config Config
+	var filepaths []string
+	for _, file := range files {
+		matches, _ := filepath.Glob(filepath.Join(file))
+		filepaths = append(filepaths, matches...)
+	}
 	if err := configor.Load(&config, filepaths...); err != nil {
 		panic(err)
 	}
-
+	return config
 }
+
 func TruncateTables(db *gorm.DB, tables ...interface{}) {
 	for _, table := range tables {
 		if err := db.DropTableIfExists(table).Error; err != nil {
@@ -41,22 +64,45 @@ func TruncateTables(db *gorm.DB, tables ...interface{}) {
 	}
 }
 
-func createQuestions(db *gorm.DB) {
+func createQuestions(db *gorm.DB, config Config, langCode string) {
 	for _, p := range config.Normals {
-		ques := strings.Replace(p.Question, "<BLANK>", "______", -1)
-		ques = strings.Replace(ques, "<i>", "", -1)
-		ques = strings.Replace(ques, "<i/>", "", -1)
-		q := models.Question{
-			Category:           p.Category,
-			Question:           ques,
-			Answer:             p.Answer,
-			AlternateSpellings: strings.Join(p.AlternateSpellings, ","),
-			Suggestions:        strings.Join(p.Suggestions, ","),
-			LangCode:           "ru",
-		}
-		if err := db.Create(&q).Error; err != nil {
+		createQuestion(db, p, false, langCode)
+	}
+	for _, p := range config.Finals {
+		createQuestion(db, p, true, langCode)
+	}
+}
+
+func createQuestion(db *gorm.DB, p Normal, final bool, langCode string) {
+	ques := strings.Replace(p.Question, "<BLANK>", "______", -1)
+	ques = strings.Replace(ques, "<i>", "", -1)
+	ques = strings.Replace(ques, "<i/>", "", -1)
+	var q models.Question
+	// seeding again updates the questions already in the table instead of adding them twice
+	err := db.Where(models.Question{Question: ques, Answer: p.Answer, LangCode: langCode}).
+		Assign(map[string]interface{}{
+			"category":            p.Category,
+			"alternate_spellings": strings.Join(p.AlternateSpellings, ","),
+			"suggestions":         strings.Join(p.Suggestions, ","),
+			"explicit":            p.Explicit,
+			"final":               final,
+		}).
+		FirstOrCreate(&q).Error
+	if err != nil {
+		log.Error(err)
+		log.Infof("%+v", q)
+	}
+}
+
+func createTemplates(db *gorm.DB, config Config, langCode string) {
+	for _, p := range config.About {
+		var t models.QuestionTemplate
+		err := db.Where(models.QuestionTemplate{Template: p.Question, LangCode: langCode}).
+			Assign(map[string]interface{}{"explicit": p.Explicit}).
+			FirstOrCreate(&t).Error
+		if err != nil {
 			log.Error(err)
-			log.Infof("%+v", q)
+			log.Infof("%+v", t)
 		}
 	}
 }
@@ -78,7 +124,12 @@ func main() {
 	//TruncateTables(db,Tables...)
 	//log.Info("Start create sample data...")
 
-	createQuestions(db)
-	log.Info("--> Created questions.")
+	for _, seed := range seeds {
+		config := loadConfig(seed.Files)
+		createQuestions(db, config, seed.LangCode)
+		log.Infof("--> Created %d questions and %d finals for %s.", len(config.Normals), len(config.Finals), seed.LangCode)
+		createTemplates(db, config, seed.LangCode)
+		log.Infof("--> Created %d question templates for %s.", len(config.About), seed.LangCode)
+	}
 
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
@@ -10,12 +9,22 @@ import (
 	"github.com/topfreegames/pitaya/component"
 	"github.com/topfreegames/pitaya/config"
 	"github.com/topfreegames/pitaya/groups"
+	"github.com/topfreegames/pitaya/logger"
 	"github.com/topfreegames/pitaya/serialize/json"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
 	"github.com/zdarovich/fibbage-game-server/internal/services/game/factsv2"
 	"github.com/zdarovich/fibbage-game-server/pkg/acceptor"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// connectorType is the server type of the frontends in a cluster
+const connectorType = "connector"
+
 func main() {
 
 	defer pitaya.Shutdown()
@@ -24,12 +33,14 @@ func main() {
 	conf := configApp()
 
 	pitaya.SetSerializer(s)
+	// the group of a room is only ever used by the server that owns the room
 	gsi := groups.NewMemoryGroupService(config.NewConfig(conf))
 	pitaya.InitGroups(gsi)
-	err := pitaya.GroupCreate(context.Background(), conf.GetString("group.uuid"))
-	if err != nil {
-		panic(err)
+	if conf.GetBool("game.cluster.connector") {
+		runConnector(conf)
+		return
 	}
+
 	connStr := fmt.Sprintf(
 		"%s:%s@(%s)/fibbage_db?charset=utf8&parseTime=True&loc=Local",
 		conf.Get("db.user"),
@@ -40,26 +51,130 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	g := factsv2.New(conf.GetString("group.uuid"), db)
-	pitaya.Register(g,
+	err = db.AutoMigrate(&models.Room{}, &models.Question{}, &models.QuestionTemplate{}).Error
+	if err != nil {
+		panic(err)
+	}
+	// in a cluster the game servers sit behind connectors that route each
+	// room's requests to the server owning it, otherwise this server takes the clients
+	clustered := conf.GetBool("game.cluster.enabled")
+	pitaya.Configure(!clustered, "game", pitaya.Cluster, map[string]string{}, conf)
+	var owners factsv2.Owners
+	if clustered {
+		factsv2.FrontendType = connectorType
+		owners, err = newOwners(conf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	rooms := factsv2.NewRooms(db, factsv2.Expiry{
+		Idle:    conf.GetDuration("game.room.idle"),
+		Hard:    conf.GetDuration("game.room.expiry"),
+		Warning: conf.GetDuration("game.room.warning"),
+	}, owners)
+	pitaya.Register(rooms,
 		component.WithName("game"),
 		component.WithNameFunc(strings.ToLower),
 	)
-	//t := acceptor.NewWSAcceptor(":3250")
+	if clustered {
+		pitaya.RegisterRemote(factsv2.NewRemote(rooms),
+			component.WithName("rooms"),
+			component.WithNameFunc(strings.ToLower),
+		)
+	} else {
+		//t := acceptor.NewWSAcceptor(":3250")
+		t := acceptor.NewWSAcceptor(":3250")
+		pitaya.AddAcceptor(t)
+	}
+
+	grace := conf.GetDuration("game.drain.grace")
+	go serveAdmin(conf.GetString("game.admin.addr"), rooms, grace)
+
+	drainOnSignal(rooms, grace)
+	pitaya.Start()
+}
+
+// runConnector takes the client connections and forwards the game requests
+// to the server that owns the room, clients address them as game.game.*
+func runConnector(conf *viper.Viper) {
 	t := acceptor.NewWSAcceptor(":3250")
 	pitaya.AddAcceptor(t)
-
-	pitaya.Configure(true, "game", pitaya.Cluster, map[string]string{}, conf)
+	pitaya.Configure(true, connectorType, pitaya.Cluster, map[string]string{}, conf)
+	owners, err := newOwners(conf)
+	if err != nil {
+		panic(err)
+	}
+	err = pitaya.AddRoute("game", factsv2.NewRoomRouter(owners))
+	if err != nil {
+		panic(err)
+	}
+	factsv2.ForwardDisconnects(owners)
 	pitaya.Start()
 }
 
+// newOwners connects to the etcd that records which server owns each room
+func newOwners(conf *viper.Viper) (factsv2.Owners, error) {
+	return factsv2.NewEtcdOwners(
+		conf.GetStringSlice("pitaya.cluster.sd.etcd.endpoints"),
+		conf.GetString("game.cluster.prefix"),
+		pitaya.GetServerID(),
+		conf.GetDuration("game.cluster.ttl"),
+	)
+}
+
+// drainOnSignal lets running games finish before the server shuts down on SIGTERM,
+// it must be called right before pitaya.Start. pitaya.Start stops the server as soon
+// as it gets SIGTERM, so the signal is ignored until pitaya registers for it and
+// taken over right after that, however long the start up takes
+func drainOnSignal(rooms *factsv2.Rooms, grace time.Duration) {
+	signal.Ignore(syscall.SIGTERM)
+	go func() {
+		for signal.Ignored(syscall.SIGTERM) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		sg := make(chan os.Signal, 1)
+		signal.Ignore(syscall.SIGTERM) // drops the handler of pitaya
+		signal.Notify(sg, syscall.SIGTERM)
+		<-sg
+		logger.Log.Warn("got SIGTERM, draining rooms...")
+		rooms.Drain(grace)
+		pitaya.Shutdown()
+	}()
+}
+
+// serveAdmin exposes the drain call for deploy hooks, it blocks until the server is drained
+func serveAdmin(addr string, rooms *factsv2.Rooms, grace time.Duration) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rooms.Drain(grace)
+		w.WriteHeader(http.StatusOK)
+		go pitaya.Shutdown()
+	})
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
 func configApp() *viper.Viper {
 	conf := viper.New()
 	conf.SetDefault("pitaya.buffer.handler.localprocess", 15)
 	conf.Set("pitaya.heartbeat.interval", "15s")
 	conf.Set("pitaya.buffer.agent.messages", 32)
 	conf.Set("pitaya.handler.messages.compression", false)
-	conf.SetDefault("group.uuid", "game")
+	conf.SetDefault("game.room.idle", "15m")
+	conf.SetDefault("game.room.expiry", "4h")
+	conf.SetDefault("game.room.warning", "1m")
+	conf.SetDefault("game.drain.grace", "5m")
+	conf.SetDefault("game.admin.addr", "127.0.0.1:3251")
+	conf.SetDefault("game.cluster.enabled", false)
+	conf.SetDefault("game.cluster.connector", false)
+	conf.SetDefault("game.cluster.prefix", "fibbage/rooms/")
+	conf.SetDefault("game.cluster.ttl", "10s")
 	conf.SetDefault("db.user", "newuser")
 	conf.SetDefault("db.password", "password")
 	conf.SetDefault("db.host", "localhost")
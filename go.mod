@@ -5,6 +5,7 @@ go 1.13
 require (
 	cloud.google.com/go v0.46.3
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869
+	github.com/coreos/etcd v3.3.13+incompatible
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang/protobuf v1.3.3 // indirect
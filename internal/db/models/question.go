@@ -10,6 +10,17 @@ type Question struct {
 	AlternateSpellings string
 	Suggestions        string
 	LangCode           string
+	Explicit           bool `gorm:"not null;default:false"`
+	Final              bool `gorm:"not null;default:false"`
+}
+
+// QuestionTemplate is a question about a player of the room, <PLAYER> is replaced
+// with the player's name and the player supplies the answer
+type QuestionTemplate struct {
+	gorm.Model
+	Template string
+	LangCode string
+	Explicit bool `gorm:"not null;default:false"`
 }
 
 type QuestionTranslation struct {
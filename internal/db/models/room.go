@@ -1,10 +1,29 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type OutcomeType string
+
+const (
+	COMPLETED OutcomeType = "COMPLETED"
+	ABANDONED OutcomeType = "ABANDONED"
+	ABORTED   OutcomeType = "ABORTED"
+)
 
 type Room struct {
 	gorm.Model
-	Uuid      string
-	StateType StateType
-	ModeType  ModeType
+	Uuid       string `gorm:"index"`
+	StateType  StateType
+	ModeType   ModeType
+	Phase      string
+	Players    int
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	Outcome    OutcomeType
+	Winner     string
+	ClosedAt   *time.Time
+	Snapshot   string `gorm:"type:text"`
 }
@@ -1,7 +1,16 @@
 package errors
 
 const (
-	EVENT_FAILED string = "EVENT_FAILED"
-	EMPTY_FIELD  string = "EMPTY_FIELD"
-	INPUT_FAILED string = "INPUT_FAILED"
+	EVENT_FAILED       string = "EVENT_FAILED"
+	EMPTY_FIELD        string = "EMPTY_FIELD"
+	INPUT_FAILED       string = "INPUT_FAILED"
+	NOT_HOST           string = "NOT_HOST"
+	STILL_CONNECTED    string = "STILL_CONNECTED"
+	BANNED             string = "BANNED"
+	ROOM_FULL          string = "ROOM_FULL"
+	NOT_ENOUGH_PLAYERS string = "NOT_ENOUGH_PLAYERS"
+	INVALID_SETTINGS   string = "INVALID_SETTINGS"
+	SERVER_DRAINING    string = "SERVER_DRAINING"
+	INVALID_WAGER      string = "INVALID_WAGER"
+	LIE_IS_TRUTH       string = "LIE_IS_TRUTH"
 )
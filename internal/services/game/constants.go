@@ -9,6 +9,60 @@ const (
 	ANSWER_TRUTH = "ANSWER_TRUTH"
 	ANSWERID     = "ANSWERID"
 	SCORE        = "SCORE"
+	ROOM         = "ROOM"
+)
+
+const (
+	// RoomCodeAlphabet leaves out letters that are easy to confuse when typed on a phone
+	RoomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	RoomCodeLength   = 4
+)
+
+const (
+	// IDLE and EXPIRED tell members why their room is being closed
+	IDLE      = "IDLE"
+	EXPIRED   = "EXPIRED"
+	DRAINING  = "DRAINING"
+	ABANDONED = "ABANDONED"
+)
+
+const (
+	MinPlayers = 2
+	MaxPlayers = 8
+	// Countdown is the number of seconds between everybody readying up and the match start
+	Countdown = 5
+)
+
+const (
+	// limits of the settings a room can be created with
+	MaxPlayersLimit = 16
+	MaxPhaseTicks   = 300
+	MaxRounds       = 5
+	// MaxQuestions and MaxMultiplier bound a single round of the round plan
+	MaxQuestions  = 16
+	MaxMultiplier = 5
+)
+
+const (
+	// PLAYER and BLANK mark where the player's name and the answer go in a question template
+	PLAYER = "<PLAYER>"
+	BLANK  = "<BLANK>"
+)
+
+const (
+	// SHARE pays every author of a picked lie the full points, SPLIT divides them between the authors
+	SHARE = "SHARE"
+	SPLIT = "SPLIT"
+)
+
+const (
+	// FinalMultiplier scales the points of the final round, wagers are paid at face value
+	FinalMultiplier = 3
+)
+
+var (
+	// Languages lists the question sets rooms can be played with
+	Languages = []string{"ru", "en"}
 )
 
 var (
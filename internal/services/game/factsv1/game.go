@@ -14,6 +14,7 @@ import (
 	"github.com/topfreegames/pitaya/session"
 	"github.com/topfreegames/pitaya/timer"
 	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	errors2 "github.com/zdarovich/fibbage-game-server/internal/errors"
 	"github.com/zdarovich/fibbage-game-server/internal/services"
 	"github.com/zdarovich/fibbage-game-server/internal/services/game"
 	"github.com/zdarovich/fibbage-game-server/internal/services/game/event"
@@ -24,6 +25,8 @@ import (
 	"time"
 )
 
+var errInterrupted = errors.New("interrupted")
+
 type (
 	// Game represents a component that contains a bundle of room related handler
 	// like Join/Status
@@ -34,6 +37,7 @@ type (
 		db        *gorm.DB
 		groupUuid string
 		done      chan struct{}
+		stopped   chan struct{}
 		players   map[string]*Player
 	}
 )
@@ -77,7 +81,12 @@ func (r *Game) Start(ctx context.Context, msg []byte) (*Response, error) {
 	//	}
 	//}
 
-	go r.loop()
+	stopped := make(chan struct{})
+	r.stopped = stopped
+	go func() {
+		defer close(stopped)
+		r.loop()
+	}()
 
 	return &Response{Result: "success"}, nil
 }
@@ -209,11 +218,31 @@ func (r *Game) reset() {
 	r.players = players
 }
 
+// Stop aborts the running match and returns the room to WAITING
 func (r *Game) Stop(ctx context.Context, msg []byte) (*Response, error) {
+	if r.stopped == nil || r.state.Current() == state.WAITING {
+		return &Response{Code: 1, Result: "fail"}, nil
+	}
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	<-r.stopped
 
 	return &Response{Result: "success"}, nil
 }
 
+// wait sleeps for the phase duration unless the loop is interrupted
+func (r *Game) wait(d time.Duration) error {
+	select {
+	case <-r.done:
+		return errInterrupted
+	case <-time.After(d):
+		return nil
+	}
+}
+
 func (r *Game) Input(ctx context.Context, msg *InputMessage) (*Response, error) {
 	s := pitaya.GetSessionFromCtx(ctx)
 
@@ -246,8 +275,8 @@ func (r *Game) Input(ctx context.Context, msg *InputMessage) (*Response, error)
 			return &Response{Result: "fail"}, nil
 		} else if msg.Answer == "" {
 			return &Response{Result: "fail"}, nil
-		} else if s.UID() == GetCurrentPlayerId(r.players) && msg.Answer == r.players[s.UID()].question.Answer {
-			return &Response{Result: "fail"}, nil
+		} else if current, ok := r.players[GetCurrentPlayerId(r.players)]; ok && current.question != nil && services.IsTruth(msg.Answer, current.question.Answer, current.question.Alternates) {
+			return &Response{Result: "fail", Error: errors2.LIE_IS_TRUTH}, nil
 		}
 		r.players[s.UID()].answerLie = msg.Answer
 
@@ -457,7 +486,19 @@ loop:
 			break loop
 		}
 	}
-	if errCh != nil {
+	if errCh == errInterrupted {
+		total := make(map[string]int)
+		for uid, p := range r.players {
+			total[uid] = p.totalScore
+		}
+		err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
+			State: state.ABORTED,
+			Total: total,
+		})
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	} else if errCh != nil {
 		logger.Log.Error(errCh)
 	}
 	logger.Log.Info("stop loop")
@@ -474,7 +515,10 @@ func (r *Game) starting(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -522,7 +566,10 @@ func (r *Game) one(ctx context.Context) error {
 		}
 
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -538,8 +585,9 @@ func (r *Game) playerQuestion(ctx context.Context) error {
 		r.db.First(&question, "category = ?", r.players[uid].categories[r.players[uid].categoryId])
 
 		r.players[uid].question = &Question{
-			Question: question.Question,
-			Answer:   question.Answer,
+			Question:   question.Question,
+			Answer:     question.Answer,
+			Alternates: services.SplitAlternateSpellings(question.AlternateSpellings),
 		}
 	}
 	timeWait := 5
@@ -556,7 +604,10 @@ func (r *Game) playerQuestion(ctx context.Context) error {
 			return err
 		}
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -583,6 +634,8 @@ func (r *Game) input(ctx context.Context) error {
 	}
 	for {
 		select {
+		case <-r.done:
+			return errInterrupted
 		case <-timeout:
 			return nil
 		case <-ticker.C:
@@ -647,9 +700,10 @@ func (r *Game) two(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
-
-	//time.Sleep(5 * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -719,9 +773,10 @@ func (r *Game) three(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
-
-	//time.Sleep(5 * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -766,8 +821,10 @@ func (r *Game) score(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
-	//time.Sleep(10 * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -781,6 +838,9 @@ func (r *Game) finish(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
+	err = r.wait(time.Duration(int64(timeWait)) * time.Second)
+	if err != nil {
+		return err
+	}
 	return nil
 }
@@ -70,10 +70,12 @@ type (
 	Response struct {
 		Code   int    `json:"code"`
 		Result string `json:"result"`
+		Error  string `json:"error,omitempty"`
 	}
 	Question struct {
-		Question          string `json:"question,omitempty"`
-		Answer            string `json:"answer,omitempty"`
-		ShuffledAnswerIdx int    `json:"shuffledIdx,omitempty"`
+		Question          string   `json:"question,omitempty"`
+		Answer            string   `json:"answer,omitempty"`
+		ShuffledAnswerIdx int      `json:"shuffledIdx,omitempty"`
+		Alternates        []string `json:"-"`
 	}
 )
@@ -2,6 +2,7 @@ package factsv1
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -16,7 +17,13 @@ func GetUsedIcons(players map[string]*Player) []string {
 func GetCurrentAnswers(players map[string]*Player, currentPlayerId string) []string {
 	var res []string
 	res = append(res, strings.ToLower(players[currentPlayerId].question.Answer))
-	for _, p := range players {
+	uids := make([]string, 0, len(players))
+	for uid := range players {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids) // answer indexes must not depend on map iteration order
+	for _, uid := range uids {
+		p := players[uid]
 		if p.answerLie == "" {
 			p.answerLie = fmt.Sprintf("%s's lie", p.name) // if player missed answer in round 2 return random
 		}
@@ -3,7 +3,6 @@ package factsv1
 import (
 	"fmt"
 	"github.com/bmizerany/assert"
-	"github.com/zdarovich/fibbage-game-server/internal/db/models"
 	"testing"
 )
 
@@ -18,14 +17,14 @@ func TestGetPlayersScore1(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -33,7 +32,7 @@ func TestGetPlayersScore1(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -57,14 +56,14 @@ func TestGetPlayersScore2(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -72,7 +71,7 @@ func TestGetPlayersScore2(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -97,14 +96,14 @@ func TestGetPlayersScore3(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -112,7 +111,7 @@ func TestGetPlayersScore3(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -137,14 +136,14 @@ func TestGetPlayersScore4(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -152,7 +151,7 @@ func TestGetPlayersScore4(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -177,14 +176,14 @@ func TestGetPlayersScore5(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -192,7 +191,7 @@ func TestGetPlayersScore5(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -0,0 +1,45 @@
+package factsv2
+
+import (
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+)
+
+// questions returns the pool a match of the room's mode draws its questions from,
+// About You templates keep their ids so a checkpointed pool can be restored
+func (r *Game) questions() ([]models.Question, error) {
+	if r.settings.Mode != models.ABOUTYOU {
+		return r.store.Questions(r.settings.Language, r.settings.FamilyFriendly)
+	}
+	templates, err := r.templates.Templates(r.settings.Language, r.settings.FamilyFriendly)
+	if err != nil {
+		return nil, err
+	}
+	var questions []models.Question
+	for _, t := range templates {
+		questions = append(questions, models.Question{
+			Model:    t.Model,
+			Question: t.Template,
+			LangCode: t.LangCode,
+			Explicit: t.Explicit,
+		})
+	}
+	return questions, nil
+}
+
+// isAbout reports whether the questions are about the player whose turn it is,
+// that player writes the truth instead of a lie and does not pick an answer
+func (r *Game) isAbout() bool {
+	return r.settings.Mode == models.ABOUTYOU
+}
+
+// afterTruth lets the others lie once the named player gave the truth,
+// the question is skipped when they did not
+func (r *Game) afterTruth() error {
+	p := r.players[r.turns[r.turn]]
+	if !p.ready {
+		r.resetPlayerReadiness()
+		return r.finish()
+	}
+	return r.waitInput(state.INPUT_LIE_TEXT) // the named player stays ready and writes no lie
+}
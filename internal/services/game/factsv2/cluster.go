@@ -0,0 +1,124 @@
+package factsv2
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/topfreegames/pitaya"
+	"github.com/topfreegames/pitaya/cluster"
+	"github.com/topfreegames/pitaya/component"
+	"github.com/topfreegames/pitaya/constants"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/topfreegames/pitaya/protos"
+	"github.com/topfreegames/pitaya/route"
+	"github.com/topfreegames/pitaya/router"
+	"github.com/topfreegames/pitaya/session"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game"
+	"math/rand"
+)
+
+type (
+	// Remote receives the session events frontends forward to the server that owns the room
+	Remote struct {
+		component.Base
+		rooms *Rooms
+	}
+)
+
+// disconnectRoute is the remote the frontends call when a player's session closes
+const disconnectRoute = "game.rooms.disconnect"
+
+// NewRemote returns the remote component of the rooms
+func NewRemote(rooms *Rooms) *Remote {
+	return &Remote{rooms: rooms}
+}
+
+// Disconnect tells the room that the player's frontend session has closed
+func (r *Remote) Disconnect(ctx context.Context, msg *protos.Session) (*protos.Response, error) {
+	data := make(map[string]interface{})
+	if len(msg.Data) > 0 {
+		err := json.Unmarshal(msg.Data, &data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	code, _ := data[game.ROOM].(string)
+	g := r.rooms.get(code)
+	if g == nil {
+		return &protos.Response{}, nil
+	}
+	g.onClose(msg.Uid)()
+	return &protos.Response{Data: []byte("ack")}, nil
+}
+
+// NewRoomRouter routes game requests to the server that owns the room,
+// requests that do not name a room go to any game server that is not draining
+func NewRoomRouter(owners Owners) router.RoutingFunc {
+	return func(
+		ctx context.Context,
+		rt *route.Route,
+		payload []byte,
+		servers map[string]*cluster.Server,
+	) (*cluster.Server, error) {
+		code := GetRequestedRoom(rt.Method, payload)
+		if code == "" {
+			if s := pitaya.GetSessionFromCtx(ctx); s != nil {
+				code = s.String(game.ROOM)
+			}
+		}
+		if code != "" {
+			owner, err := owners.Owner(code)
+			if err != nil {
+				return nil, err
+			}
+			if sv, ok := servers[owner]; ok {
+				return sv, nil
+			}
+		}
+		draining, err := owners.Draining()
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, 0, len(servers))
+		for id := range servers {
+			if !draining[id] {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			for id := range servers {
+				ids = append(ids, id) // a draining server still answers, if only with SERVER_DRAINING
+			}
+		}
+		if len(ids) == 0 {
+			return nil, constants.ErrNoServersAvailableOfType
+		}
+		return servers[ids[rand.Intn(len(ids))]], nil
+	}
+}
+
+// ForwardDisconnects makes the frontend tell the room owner when a player's session closes
+func ForwardDisconnects(owners Owners) {
+	session.OnSessionBind(func(ctx context.Context, s *session.Session) error {
+		return s.OnClose(func() {
+			code := s.String(game.ROOM)
+			if code == "" {
+				return
+			}
+			owner, err := owners.Owner(code)
+			if err != nil {
+				logger.Log.Error(err)
+				return
+			} else if owner == "" {
+				return
+			}
+			err = pitaya.RPCTo(context.Background(), owner, disconnectRoute, &protos.Response{}, &protos.Session{
+				Id:   s.ID(),
+				Uid:  s.UID(),
+				Data: s.GetDataEncoded(),
+			})
+			if err != nil {
+				logger.Log.Error(err)
+			}
+		})
+	})
+}
@@ -0,0 +1,109 @@
+package factsv2
+
+import (
+	"crypto/rand"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/services"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+	"math/big"
+)
+
+// finalIntro shows everybody the question of the final round,
+// the match ends right away when there is no final question to ask
+func (r *Game) finalIntro() error {
+	questions, err := r.store.Finals(r.settings.Language, r.settings.FamilyFriendly)
+	if err != nil {
+		return err
+	}
+	if len(questions) == 0 {
+		logger.Log.Errorf("no final questions for %s, the room was created without them", r.settings.Language)
+		r.complete()
+		return nil
+	}
+	var ri int64
+	randIdx, err := rand.Int(rand.Reader, big.NewInt(int64(len(questions))))
+	if err != nil {
+		logger.Log.Error(err)
+		ri = 0
+	} else {
+		ri = randIdx.Int64()
+	}
+	r.setState(state.FINAL)
+	r.final = &Question{
+		Question:   questions[ri].Question,
+		Answer:     questions[ri].Answer,
+		Alternates: services.SplitAlternateSpellings(questions[ri].AlternateSpellings),
+	}
+	r.other = &Question{Question: r.final.Question}
+	r.answers = nil
+	r.reveals = nil
+	r.reveal = 0
+
+	timeWait := r.settings.Two
+	r.schedule(timeWait)
+	return r.broadcastState(&Message{
+		State: r.state,
+		Other: r.other,
+		Ticks: timeWait,
+	})
+}
+
+func (r *Game) finalThree() error {
+	r.setState(state.FINAL_THREE)
+	r.answers = r.shuffleAnswers(r.final)
+	timeWait := r.settings.Three
+	r.schedule(timeWait)
+	return r.broadcastState(&Message{
+		State:   r.state,
+		Answers: r.answers,
+		Ticks:   timeWait,
+	})
+}
+
+// finalReveal shows the next answer of the final round, the picked lies go first and the truth last
+func (r *Game) finalReveal() error {
+	if r.state != state.FINAL_REVEAL {
+		r.setState(state.FINAL_REVEAL)
+		r.reveals = GetFinalReveals(r.players, r.answers, r.final.ShuffledAnswerIdx)
+		r.reveal = 0
+	} else {
+		r.reveal++
+	}
+	if r.reveal >= len(r.reveals) {
+		return r.finalScore()
+	}
+	timeWait := r.settings.Three
+	r.schedule(timeWait)
+	return r.broadcastState(&Message{
+		State:  r.state,
+		Reveal: r.reveals[r.reveal],
+		Ticks:  timeWait,
+	})
+}
+
+func (r *Game) finalScore() error {
+	r.setState(state.FINAL_SCORE)
+	scoreMap := GetFinalScore(r.players, r.final.ShuffledAnswerIdx, r.settings.Fooled)
+
+	finalScore := make(map[string]int)
+	for uid, score := range scoreMap {
+		r.players[uid].totalScore = r.players[uid].totalScore + score
+		finalScore[uid] = r.players[uid].totalScore
+	}
+	for _, p := range r.players {
+		p.answerLie = ""
+		p.answerTruthId = 0
+		p.wager = 0
+	}
+	r.resetPlayerReadiness()
+
+	timeWait := r.settings.Score
+	r.schedule(timeWait)
+	return r.broadcastState(&Message{
+		State:  r.state,
+		Score:  scoreMap,
+		Total:  finalScore,
+		Series: GetSeries(r.players),
+		Ticks:  timeWait,
+	})
+}
@@ -8,61 +8,164 @@ import (
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 	"github.com/topfreegames/pitaya"
-	"github.com/topfreegames/pitaya/component"
 	"github.com/topfreegames/pitaya/logger"
 	"github.com/topfreegames/pitaya/session"
-	"github.com/topfreegames/pitaya/timer"
 	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	errors2 "github.com/zdarovich/fibbage-game-server/internal/errors"
 	"github.com/zdarovich/fibbage-game-server/internal/services"
 	"github.com/zdarovich/fibbage-game-server/internal/services/game"
 	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
 	"math/big"
 	mathRand "math/rand"
+	"strings"
 	"time"
 )
 
 type (
-	// Game represents a component that contains a bundle of room related handler
-	// like Join/Status
+	// Game represents a single room bound to its own pitaya group.
+	// All of its state is owned by the run goroutine: handlers, phase timers
+	// and session callbacks hand it commands over the cmds channel
 	Game struct {
-		component.Base
-		timer     *timer.Timer
 		db        *gorm.DB
+		messenger Messenger
+		store     QuestionStore
+		templates TemplateStore
 		groupUuid string
-		done      chan struct{}
+		cmds      chan func()
+		closed    chan struct{}
+		timer     *time.Timer
+		seq       int
 		state     string
 		players   map[string]*Player
+		tokens    map[string]string
+		banned    map[string]bool
+		settings  RoomSettings
+		lineup    []string
+		turns     []string
+		turn      int
+		plan      []Round
+		rounds    []int
+		round     int
+		pool      []models.Question
+		deadline  time.Time
+		paused    bool
+		dropped   bool
+		restored  bool
+		final     *Question
+		reveals   []*Reveal
+		reveal    int
+		counting  bool
+		played    bool
+		draining  bool
+		remaining time.Duration
+		other     *Question
+		answers   []string
+		room      *models.Room
+		createdAt time.Time
+		activeAt  time.Time
+		warned    bool
+		onEmpty   func()
+		onLeave   func(uid string)
 	}
 )
 
-// New returns a Handler Base implementation
-func New(groupUuid string, db *gorm.DB) *Game {
-	return &Game{
+// New returns a game bound to the given group and starts its command loop
+func New(groupUuid string, db *gorm.DB, settings RoomSettings) *Game {
+	r := &Game{
 		groupUuid: groupUuid,
-		done:      make(chan struct{}),
+		messenger: NewGroupMessenger(groupUuid),
+		store:     NewQuestionStore(db),
+		templates: NewTemplateStore(db),
+		cmds:      make(chan func()),
+		closed:    make(chan struct{}),
 		players:   make(map[string]*Player),
+		tokens:    make(map[string]string),
+		banned:    make(map[string]bool),
+		settings:  settings,
 		db:        db,
 		state:     state.WAITING,
+		createdAt: time.Now(),
+		activeAt:  time.Now(),
 	}
+	go r.run()
+	return r
 }
 
-// AfterInit component lifetime callback
-func (r *Game) AfterInit() {
-	r.timer = pitaya.NewTimer(time.Minute, func() {
-		count, err := pitaya.GroupCountMembers(context.Background(), r.groupUuid)
-		logger.Log.Debugf("UserCount: Time=> %s, Count=> %d, Error=> %q", time.Now().String(), count, err)
+// run executes commands one at a time until the room is closed
+func (r *Game) run() {
+	for {
+		select {
+		case fn := <-r.cmds:
+			fn()
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+// exec runs fn on the goroutine that owns the game state and waits for it.
+// It returns false if the room was closed before fn could run
+func (r *Game) exec(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.cmds <- func() {
+		defer close(done)
+		fn()
+	}:
+	case <-r.closed:
+		return false
+	}
+	<-done
+	return true
+}
+
+// act runs a player command like exec and counts it as activity in the room
+func (r *Game) act(fn func()) bool {
+	return r.exec(func() {
+		r.touch()
+		fn()
 	})
 }
 
+// send queues fn without waiting for it to run
+func (r *Game) send(fn func()) {
+	select {
+	case r.cmds <- fn:
+	case <-r.closed:
+	}
+}
+
+// close stops the phase timer and the command loop, it must run on the command loop
+func (r *Game) close() {
+	r.stopTimer()
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+}
+
 func (r *Game) Start(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.start(s.UID())
+	})
+	return res, nil
+}
 
-	if r.state != state.WAITING {
-		return &Response{Code: 1, Result: "fail"}, nil
+func (r *Game) start(uid string) *Response {
+	if !r.isHost(uid) {
+		return &Response{Result: "fail", Error: errors2.NOT_HOST}
+	} else if r.state != state.WAITING {
+		return &Response{Code: 1, Result: "fail"}
+	} else if len(GetPlayerIds(r.players, r.memberIds())) < r.settings.MinPlayers {
+		return &Response{Result: "fail", Error: errors2.NOT_ENOUGH_PLAYERS}
 	}
 
-	go r.loop()
+	r.launch()
 
-	return &Response{Result: "success"}, nil
+	return &Response{Result: "success"}
 }
 
 // Join room
@@ -70,27 +173,172 @@ func (r *Game) Join(ctx context.Context, msg *NicknameMessage) (*Response, error
 	s := pitaya.GetSessionFromCtx(ctx)
 	if msg == nil || msg.Nickname == "" {
 		return &Response{Result: "fail"}, nil
+	}
+
+	if s.UID() == "" {
+		err := s.Bind(ctx, uuid.New().String()) // binding session uid
+		if err != nil {
+			return nil, pitaya.Error(err, "RH-000", map[string]string{"failed": "bind"})
+		}
+	}
+	res := &Response{Result: "fail"}
+	var err error
+	r.act(func() {
+		res, err = r.join(s.UID(), msg.Nickname)
+	})
+	if err != nil || res.Result != "success" {
+		return res, err
+	}
+	s.OnClose(r.onClose(s.UID()))
+
+	return res, nil
+}
+
+func (r *Game) join(uid string, nickname string) (*Response, error) {
+	if r.banned[uid] {
+		return &Response{Result: "fail", Error: errors2.BANNED}, nil
 	} else if r.state != state.WAITING {
 		logger.Log.Infof("wrong state to join: %s", r.state)
 		return &Response{Result: "fail"}, nil
 	}
+	if GetPlayerCount(r.players) >= r.settings.MaxPlayers {
+		r.freeSeats()
+	}
+	if GetPlayerCount(r.players) >= r.settings.MaxPlayers {
+		return &Response{Result: "fail", Error: errors2.ROOM_FULL}, nil
+	}
 
-	err := s.Bind(ctx, uuid.New().String()) // binding session uid
+	err := r.messenger.AddMember(uid) // add session to group
+	if err != nil {
+		return nil, err
+	}
+	r.players[uid] = &Player{}
+	r.players[uid].name = nickname
+	r.players[uid].connected = true
+	r.players[uid].joinedAt = time.Now()
+	r.players[uid].token = uuid.New().String()
+	r.tokens[r.players[uid].token] = uid
+	r.cancelCountdown() // the newcomer has not readied up yet
+	if hostId := GetHostId(r.players); hostId == "" || !r.players[hostId].connected {
+		if hostId != "" {
+			r.players[hostId].host = false
+		}
+		r.players[uid].host = true // first joiner hosts the room, or takes it over from a host that left
+	}
 
+	uids, err := r.messenger.Members()
 	if err != nil {
-		return nil, pitaya.Error(err, "RH-000", map[string]string{"failed": "bind"})
+		return nil, err
+	}
+	r.players[uid].iconName = r.pickIcon()
+
+	var users []User
+	for _, memberId := range uids {
+		users = append(users, User{
+			UID:         memberId,
+			Name:        r.players[memberId].name,
+			Icon:        r.players[memberId].iconName,
+			IsPlayer:    memberId == uid,
+			IsHost:      r.players[memberId].host,
+			IsSpectator: r.players[memberId].spectator,
+		})
+	}
+	for _, memberId := range uids {
+		if memberId == uid {
+			err := r.messenger.Push(uid, "onCreatePlayer", users)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err := r.messenger.Push(memberId, "onCreatePlayer", []User{{
+			UID:    uid,
+			Name:   r.players[uid].name,
+			Icon:   r.players[uid].iconName,
+			IsHost: r.players[uid].host,
+		}})
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}
+
+	return &Response{Code: 1, Result: "success", Token: r.players[uid].token}, nil
+}
+
+// freeSeats drops the players that left the lobby so newcomers can take their seats,
+// they can still rejoin until somebody needs the seat
+func (r *Game) freeSeats() {
+	for uid, p := range r.players {
+		if p.connected || p.spectator {
+			continue
+		}
+		delete(r.tokens, p.token)
+		delete(r.players, uid)
+	}
+}
+
+// Spectate adds the session to the room broadcasts without taking part in the match
+func (r *Game) Spectate(ctx context.Context, msg *NicknameMessage) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil || msg.Nickname == "" {
+		return &Response{Result: "fail"}, nil
+	}
+
+	if s.UID() == "" {
+		err := s.Bind(ctx, uuid.New().String()) // binding session uid
+		if err != nil {
+			return nil, pitaya.Error(err, "RH-000", map[string]string{"failed": "bind"})
+		}
 	}
-	err = pitaya.GroupAddMember(ctx, r.groupUuid, s.UID()) // add session to group
+	res := &Response{Result: "fail"}
+	var err error
+	r.act(func() {
+		res, err = r.spectate(s.UID(), msg.Nickname)
+	})
+	if err != nil || res.Result != "success" {
+		return res, err
+	}
+	s.OnClose(r.onClose(s.UID()))
+
+	return res, nil
+}
+
+func (r *Game) spectate(uid string, nickname string) (*Response, error) {
+	if r.banned[uid] {
+		return &Response{Result: "fail", Error: errors2.BANNED}, nil
+	}
+	err := r.messenger.AddMember(uid) // add session to group
 	if err != nil {
 		return nil, err
 	}
-	r.players[s.UID()] = &Player{}
-	r.players[s.UID()].name = msg.Nickname
+	p := &Player{
+		name:      nickname,
+		connected: true,
+		joinedAt:  time.Now(),
+		token:     uuid.New().String(),
+		spectator: true,
+	}
+	r.players[uid] = p
+	r.tokens[p.token] = uid
 
-	uids, err := pitaya.GroupMembers(ctx, r.groupUuid)
+	err = r.messenger.Broadcast("onSpectatorJoined", &User{
+		UID:         uid,
+		Name:        p.name,
+		IsSpectator: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.pushSnapshot(uid)
 	if err != nil {
 		return nil, err
 	}
+
+	return &Response{Code: 1, Result: "success", Token: p.token}, nil
+}
+
+// pickIcon returns a random icon that no other player in the room uses
+func (r *Game) pickIcon() string {
 	usedIcons := make(map[string]bool)
 	for _, p := range r.players {
 		usedIcons[p.iconName] = true
@@ -102,6 +350,9 @@ func (r *Game) Join(ctx context.Context, msg *NicknameMessage) (*Response, error
 		}
 		tempIcons = append(tempIcons, i)
 	}
+	if len(tempIcons) == 0 {
+		tempIcons = game.IconSet // more players than icons, some have to share
+	}
 	iconsCount := len(tempIcons)
 	var ri int64
 	randIdx, err := rand.Int(rand.Reader, big.NewInt(int64(iconsCount)))
@@ -111,69 +362,273 @@ func (r *Game) Join(ctx context.Context, msg *NicknameMessage) (*Response, error
 	} else {
 		ri = randIdx.Int64()
 	}
-	r.players[s.UID()].iconName = tempIcons[ri]
+	return tempIcons[ri]
+}
+
+// Rejoin rebinds a fresh session to the player that owns the resume token
+func (r *Game) Rejoin(ctx context.Context, msg *RejoinMessage) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil || msg.Token == "" || s.UID() != "" {
+		return &Response{Result: "fail"}, nil
+	}
+	res := &Response{Result: "fail"}
+	var err error
+	r.act(func() {
+		res, err = r.rejoin(ctx, s, msg.Token)
+	})
+	if err != nil || res.Result != "success" {
+		return res, err
+	}
+	s.OnClose(r.onClose(s.UID()))
+
+	return res, nil
+}
+
+func (r *Game) rejoin(ctx context.Context, s *session.Session, token string) (*Response, error) {
+	uid, ok := r.tokens[token]
+	if r.banned[token] {
+		return &Response{Result: "fail", Error: errors2.BANNED}, nil
+	} else if !ok {
+		return &Response{Result: "fail"}, nil
+	} else if r.players[uid].connected {
+		return &Response{Result: "fail", Error: errors2.STILL_CONNECTED}, nil
+	}
+
+	err := s.Bind(ctx, uid)
+	if err != nil {
+		return nil, pitaya.Error(err, "RH-000", map[string]string{"failed": "bind"})
+	}
+	err = r.messenger.AddMember(uid)
+	if err != nil {
+		return nil, err
+	}
+	p := r.players[uid]
+	p.connected = true
+	if GetHostId(r.players) == "" {
+		r.migrateHost() // the room lost its host while nobody could take over
+	}
 
+	err = r.messenger.Broadcast("onPlayerReconnected", &User{
+		UID:    uid,
+		Name:   p.name,
+		Icon:   p.iconName,
+		IsHost: p.host,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.pushSnapshot(uid)
+	if err != nil {
+		return nil, err
+	}
+	r.checkRestored()
+
+	return &Response{Code: 1, Result: "success", Token: p.token}, nil
+}
+
+// pushSnapshot sends the player everything needed to redraw the current phase
+func (r *Game) pushSnapshot(uid string) error {
+	uids, err := r.messenger.Members()
+	if err != nil {
+		return err
+	}
 	var users []User
-	for _, uid := range uids {
-		if uid == s.UID() {
-			users = append(users, User{
-				UID:      uid,
-				Name:     r.players[uid].name,
-				Icon:     r.players[uid].iconName,
-				IsPlayer: true,
-			})
-		} else {
-			users = append(users, User{
-				UID:  uid,
-				Name: r.players[uid].name,
-				Icon: r.players[uid].iconName,
-			})
-		}
+	for _, memberId := range uids {
+		users = append(users, User{
+			UID:         memberId,
+			Name:        r.players[memberId].name,
+			Icon:        r.players[memberId].iconName,
+			IsPlayer:    memberId == uid,
+			IsHost:      r.players[memberId].host,
+			IsSpectator: r.players[memberId].spectator,
+		})
+	}
+	err = r.messenger.Push(uid, "onCreatePlayer", users)
+	if err != nil {
+		return err
+	}
 
+	total := make(map[string]int)
+	for playerId, p := range r.players {
+		total[playerId] = p.totalScore
 	}
-	for _, uid := range uids {
-		if uid == s.UID() {
-			err := s.Push("onCreatePlayer", users)
-			if err != nil {
-				return nil, err
-			}
-			continue
-		}
-		sess := session.GetSessionByUID(uid)
-		err := sess.Push("onCreatePlayer", []User{{
-			UID:  s.UID(),
-			Name: r.players[s.UID()].name,
-			Icon: r.players[s.UID()].iconName,
-		}})
-		if err != nil {
-			return nil, err
-		}
+	var question *Question
+	if q := r.players[uid].question; q != nil {
+		question = &Question{Question: q.Question}
 	}
+	return r.messenger.Push(uid, "onState", r.stamp(&Message{
+		State:    r.state,
+		Ticks:    r.remainingTicks(),
+		Question: question,
+		Other:    r.other,
+		Answers:  r.answers,
+		Total:    total,
+		Paused:   r.paused,
+	}))
+}
 
-	// on session close, remove it from group
-	s.OnClose(func() {
-		pitaya.GroupRemoveMember(ctx, r.groupUuid, s.UID())
-		count, _ := pitaya.GroupCountMembers(context.Background(), r.groupUuid)
-		if count == 0 {
-			r.reset()
-		} else {
-			pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onPlayerDisconnected", &User{UID: s.UID()})
+// onClose returns the session close callback that removes the player from the group
+func (r *Game) onClose(uid string) func() {
+	return func() {
+		r.send(func() {
+			r.disconnect(uid)
+		})
+	}
+}
+
+func (r *Game) disconnect(uid string) {
+	p, ok := r.players[uid]
+	if !ok {
+		return // kicked players already left the group
+	}
+	err := r.messenger.RemoveMember(uid)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	p.connected = false
+	members, _ := r.messenger.Members()
+	if len(members) == 0 {
+		r.reset()
+		if r.onEmpty != nil {
+			r.onEmpty()
 		}
+		r.close()
+		return
+	}
+	err = r.messenger.Broadcast("onPlayerDisconnected", &User{UID: uid})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	if r.isHost(uid) {
+		r.migrateHost()
+	}
+	r.checkReady() // nobody waits for a player that is gone
+	r.cancelCountdown()
+	r.checkRematch()
+}
+
+// Kick removes a player from the room, optionally banning them from coming back
+func (r *Game) Kick(ctx context.Context, msg *KickMessage) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil || msg.UID == "" {
+		return &Response{Result: "fail"}, nil
+	}
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.kick(s.UID(), msg.UID, msg.Ban)
+	})
+	return res, nil
+}
+
+func (r *Game) kick(hostId string, uid string, ban bool) *Response {
+	if !r.isHost(hostId) {
+		return &Response{Result: "fail", Error: errors2.NOT_HOST}
+	}
+	p, ok := r.players[uid]
+	if !ok || uid == hostId {
+		return &Response{Result: "fail"}
+	}
+
+	err := r.messenger.Broadcast("onPlayerKicked", &User{
+		UID:  uid,
+		Name: p.name,
 	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	err = r.messenger.RemoveMember(uid)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	delete(r.players, uid)
+	delete(r.tokens, p.token)
+	if ban {
+		r.banned[uid] = true
+		r.banned[p.token] = true
+	}
+	if r.onLeave != nil {
+		r.onLeave(uid)
+	}
+	r.removeTurn(uid)
+	r.cancelCountdown()
+	r.checkReady() // the kicked player may be the last one everybody waited for
 
-	return &Response{Code: 1, Result: "success"}, nil
+	return &Response{Code: 1, Result: "success"}
 }
 
+// removeTurn drops the player from the match order, ending their round if it is being played
+func (r *Game) removeTurn(uid string) {
+	lineup := make([]string, 0, len(r.lineup))
+	for _, playerId := range r.lineup {
+		if playerId != uid {
+			lineup = append(lineup, playerId)
+		}
+	}
+	r.lineup = lineup
+
+	current := r.turn >= 0 && r.turn < len(r.turns) && r.turns[r.turn] == uid
+	turns := make([]string, 0, len(r.turns))
+	rounds := make([]int, 0, len(r.rounds))
+	turn := r.turn
+	for i, turnId := range r.turns {
+		if turnId != uid {
+			turns = append(turns, turnId)
+			if i < len(r.rounds) {
+				rounds = append(rounds, r.rounds[i])
+			}
+		} else if i <= r.turn {
+			turn--
+		}
+	}
+	r.turns = turns
+	r.rounds = rounds
+	r.turn = turn
+	if !current || r.state == state.FINISH {
+		return
+	} else if r.paused {
+		r.dropped = true // the round ends once the room is resumed
+		return
+	}
+	// the round was about the kicked player's question
+	err := r.finish()
+	if err != nil {
+		logger.Log.Infof("loop aborted: %s", err)
+		r.abort()
+	}
+}
+
+// reset empties the room once its last member left and marks its row closed
 func (r *Game) reset() {
-	r.done = make(chan struct{})
+	r.stopTimer()
 	r.players = make(map[string]*Player)
+	r.tokens = make(map[string]string)
 	r.state = state.WAITING
+	r.saveFinished(models.ABANDONED, "")
+	r.saveClosed()
 }
 
 func (r *Game) restart() {
-	r.done = make(chan struct{})
+	r.stopTimer()
 	players := make(map[string]*Player)
 	r.state = state.WAITING
+	r.lineup = nil
+	r.turns = nil
+	r.turn = 0
+	r.plan = nil
+	r.rounds = nil
+	r.round = 0
+	r.pool = nil
+	r.deadline = time.Time{}
+	r.paused = false
+	r.dropped = false
+	r.counting = false
+	r.remaining = 0
+	r.other = nil
+	r.answers = nil
+	r.final = nil
+	r.reveals = nil
+	r.reveal = 0
+	r.createRoom()
 	for uid, p := range r.players {
 		resetPlayer := &Player{
 			name:              p.name,
@@ -185,179 +640,607 @@ func (r *Game) restart() {
 			shuffledAnswerIdx: 0,
 			answerTruthId:     0,
 			iconName:          p.iconName,
+			spectator:         p.spectator,
 			ready:             false,
 			used:              false,
 			current:           false,
-			connected:         true,
+			connected:         p.connected,
+			host:              p.host,
+			joinedAt:          p.joinedAt,
+			token:             p.token,
+			seriesScore:       p.seriesScore,
+			seriesWins:        p.seriesWins,
 		}
 		players[uid] = resetPlayer
 	}
 	r.players = players
-	_ = pitaya.GroupBroadcast(context.Background(), "game", r.groupUuid, "onState", &Message{
-		State: r.state,
+	for _, p := range r.players {
+		if p.spectator && GetPlayerCount(r.players) < r.settings.MaxPlayers {
+			p.spectator = false // spectators join the next match as players
+			p.iconName = r.pickIcon()
+		}
+	}
+	_ = r.broadcastState(&Message{
+		State:  r.state,
+		Series: GetSeries(r.players), // the standings now count the match that just ended
+	})
+}
+
+// Restart starts a new match with the same lobby, aborting the running one if needed
+func (r *Game) Restart(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		if !r.isHost(s.UID()) {
+			res = &Response{Result: "fail", Error: errors2.NOT_HOST}
+			return
+		} else if r.draining {
+			res = &Response{Result: "fail", Error: errors2.SERVER_DRAINING}
+			return
+		} else if len(GetPlayerIds(r.players, r.memberIds())) < r.settings.MinPlayers {
+			res = &Response{Result: "fail", Error: errors2.NOT_ENOUGH_PLAYERS}
+			return
+		}
+		if r.state != state.WAITING {
+			r.abort() // aborting the running match returns the room to WAITING
+		} else {
+			r.restart()
+		}
+		r.launch()
+		res = &Response{Code: 1, Result: "success"}
+	})
+	return res, nil
+}
+
+// Has reports whether the player or spectator is in the room
+func (r *Game) Has(uid string) bool {
+	ok := false
+	r.exec(func() {
+		_, ok = r.players[uid]
 	})
+	return ok
 }
 
+func (r *Game) isHost(uid string) bool {
+	p, ok := r.players[uid]
+	return ok && p.host
+}
+
+// migrateHost passes host rights to the longest connected player,
+// the room has no host until somebody joins when there is nobody to pass them to
+func (r *Game) migrateHost() {
+	members, err := r.messenger.Members()
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	nextHostId := GetNextHostId(r.players, members)
+	for _, p := range r.players {
+		p.host = false
+	}
+	if nextHostId == "" {
+		return
+	}
+	r.players[nextHostId].host = true
+	err = r.messenger.Broadcast("onHostChanged", &User{
+		UID:    nextHostId,
+		Name:   r.players[nextHostId].name,
+		Icon:   r.players[nextHostId].iconName,
+		IsHost: true,
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// Stop aborts the running match and returns the room to WAITING
 func (r *Game) Stop(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		if !r.isHost(s.UID()) {
+			res = &Response{Result: "fail", Error: errors2.NOT_HOST}
+			return
+		} else if r.state == state.WAITING {
+			return
+		}
+		r.abort()
+		res = &Response{Code: 1, Result: "success"}
+	})
+	return res, nil
+}
 
-	return &Response{Code: 1, Result: "success"}, nil
+// Pause freezes the running phase until the host resumes the match
+func (r *Game) Pause(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.pause(s.UID())
+	})
+	return res, nil
+}
+
+func (r *Game) pause(uid string) *Response {
+	if !r.isHost(uid) {
+		return &Response{Result: "fail", Error: errors2.NOT_HOST}
+	} else if r.state == state.WAITING || r.paused {
+		return &Response{Result: "fail"}
+	}
+
+	r.remaining = r.deadline.Sub(time.Now())
+	if r.remaining < 0 {
+		r.remaining = 0
+	}
+	r.stopTimer()
+	r.paused = true
+	err := r.broadcastState(&Message{
+		State:  r.state,
+		Ticks:  r.remainingTicks(),
+		Paused: true,
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+
+	return &Response{Code: 1, Result: "success"}
+}
+
+// Resume continues the paused phase with the time that was left on it
+func (r *Game) Resume(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.resume(s.UID())
+	})
+	return res, nil
+}
+
+func (r *Game) resume(uid string) *Response {
+	if !r.isHost(uid) {
+		return &Response{Result: "fail", Error: errors2.NOT_HOST}
+	} else if !r.paused {
+		return &Response{Result: "fail"}
+	}
+
+	r.restored = false
+	r.unpause()
+
+	return &Response{Code: 1, Result: "success"}
+}
+
+// unpause continues the phase with the time that was left on it
+func (r *Game) unpause() {
+	r.paused = false
+	if r.dropped {
+		r.dropped = false
+		r.remaining = 0
+		err := r.finish() // the player of the round was kicked during the pause
+		if err != nil {
+			logger.Log.Infof("loop aborted: %s", err)
+			r.abort()
+		}
+		return
+	}
+	r.scheduleIn(r.remaining)
+	r.remaining = 0
+	err := r.broadcastState(&Message{
+		State:   r.state,
+		Ticks:   r.remainingTicks(),
+		Other:   r.other,
+		Answers: r.answers,
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	r.checkReady() // the players everybody waited for may have left during the pause
+}
+
+// remainingTicks returns the whole seconds left in the current phase
+func (r *Game) remainingTicks() int {
+	now := time.Now()
+	if r.paused {
+		return GetRemainingTicks(now.Add(r.remaining), now)
+	}
+	return GetRemainingTicks(r.deadline, now)
 }
 
 func (r *Game) Input(ctx context.Context, msg *InputMessage) (*Response, error) {
 	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	res := &Response{Result: "fail"}
+	var err error
+	r.act(func() {
+		res, err = r.input(s.UID(), msg)
+	})
+	return res, err
+}
+
+func (r *Game) input(uid string, msg *InputMessage) (*Response, error) {
+	p, ok := r.players[uid]
+	if !ok || p.spectator {
+		return &Response{Result: "fail"}, nil
+	} else if r.paused {
+		logger.Log.Infof("input while paused: %s", uid)
+		return &Response{Result: "fail"}, nil
+	} else if time.Now().After(r.deadline) {
+		logger.Log.Infof("input after deadline: %s", uid)
+		return &Response{Result: "fail"}, nil
+	}
 
 	switch r.state {
-	case state.INPUT_LIE_TEXT:
-		if r.players[s.UID()].ready {
+	case state.INPUT_TRUTH:
+		if !p.current || p.ready {
 			return &Response{Result: "fail"}, nil
 		} else if msg.Answer == "" {
 			return &Response{Result: "fail"}, nil
 		}
-		r.players[s.UID()].answerLie = msg.Answer
+		p.question.Answer = msg.Answer
 
-		r.players[s.UID()].ready = true
-		err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onReady",
-			&User{
-				UID: s.UID(),
-			},
-		)
-		if err != nil {
-			return nil, err
+	case state.INPUT_LIE_TEXT, state.INPUT_FINAL_LIE_TEXT:
+		if p.ready {
+			return &Response{Result: "fail"}, nil
+		} else if msg.Answer == "" {
+			return &Response{Result: "fail"}, nil
+		} else if truth := r.truth(); truth != nil && services.IsTruth(msg.Answer, truth.Answer, truth.Alternates) {
+			return &Response{Result: "fail", Error: errors2.LIE_IS_TRUTH}, nil
 		}
+		p.answerLie = msg.Answer
 
-		return &Response{Code: 1, Result: "success"}, nil
-
-	case state.INPUT_TRUE_OPTION:
-		if r.players[s.UID()].ready {
+	case state.INPUT_TRUE_OPTION, state.INPUT_FINAL_OPTION:
+		if p.ready || p.current {
 			return &Response{Result: "fail"}, nil
 		}
 		idx := msg.AnswerId
-		if idx >= 0 && idx < len(r.players)+1 { // each player lie answer + 1 truth answer
-			if idx == r.players[s.UID()].shuffledAnswerIdx {
-				return &Response{Result: "fail"}, nil
-			}
-			r.players[s.UID()].answerTruthId = idx
-
-			r.players[s.UID()].ready = true
-
-			err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onReady",
-				&User{
-					UID: s.UID(),
-				},
-			)
-			if err != nil {
-				return nil, err
+		if idx < 0 || idx >= len(r.answers) { // each player lie answer + 1 truth answer
+			return &Response{Result: "fail"}, nil
+		} else if idx == p.shuffledAnswerIdx {
+			return &Response{Result: "fail"}, nil
+		}
+		if r.state == state.INPUT_FINAL_OPTION {
+			if msg.Wager < 0 || msg.Wager > GetMaxWager(p.totalScore) {
+				return &Response{Result: "fail", Error: errors2.INVALID_WAGER}, nil
 			}
-			return &Response{Code: 1, Result: "success"}, nil
+			p.wager = msg.Wager
 		}
+		p.answerTruthId = idx
 
 	default:
 		logger.Log.Errorf("wrong state to input %s", r.state)
 		return &Response{Result: "fail"}, nil
 	}
-	return &Response{Result: "fail"}, nil
+
+	p.ready = true
+	err := r.messenger.Broadcast("onReady",
+		&User{
+			UID:     uid,
+			IsReady: true,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.checkReady()
+
+	return &Response{Code: 1, Result: "success"}, nil
 }
 
-func (r *Game) loop() error {
-	logger.Log.Info("start loop")
-	ctx := context.Background()
-	err := r.starting(ctx)
+// truth returns the question the players are lying to
+func (r *Game) truth() *Question {
+	if r.state == state.INPUT_FINAL_LIE_TEXT {
+		return r.final
+	} else if r.turn < 0 || r.turn >= len(r.turns) {
+		return nil
+	}
+	if p, ok := r.players[r.turns[r.turn]]; ok {
+		return p.question
+	}
+	return nil
+}
+
+// checkReady ends the input phase early once every connected player has answered
+func (r *Game) checkReady() {
+	if r.paused {
+		return // unpause checks again
+	}
+	switch r.state {
+	case state.INPUT_TRUTH:
+		if r.players[r.turns[r.turn]].ready {
+			r.advance()
+		}
+		return
+	case state.INPUT_LIE_TEXT, state.INPUT_TRUE_OPTION, state.INPUT_FINAL_LIE_TEXT, state.INPUT_FINAL_OPTION:
+	default:
+		return
+	}
+	members, err := r.messenger.Members()
 	if err != nil {
-		return err
+		logger.Log.Error(err)
+		return
+	}
+	if ArePlayersReady(r.players, members) {
+		r.advance()
+	}
+}
+
+// nextTurn moves to the first connected player from the current turn on,
+// disconnected players keep their score but their questions are skipped
+func (r *Game) nextTurn() bool {
+	for ; r.turn < len(r.turns); r.turn++ {
+		if p, ok := r.players[r.turns[r.turn]]; ok && p.connected {
+			return true
+		}
 	}
-	members, err := pitaya.GroupMembers(ctx, r.groupUuid)
+	return false
+}
+
+// launch starts a match from the lobby
+func (r *Game) launch() {
+	logger.Log.Info("start loop")
+	r.counting = false
+	r.played = false
+	for _, p := range r.players {
+		p.ready = false // lobby readiness must not count as an answer
+		p.rematch = false
+	}
+	r.saveStarted(len(GetPlayerIds(r.players, r.memberIds())))
+	err := r.starting()
 	if err != nil {
-		return err
+		logger.Log.Infof("loop aborted: %s", err)
+		r.abort()
 	}
-	err = r.one(ctx, members)
+}
+
+func (r *Game) memberIds() []string {
+	members, err := r.messenger.Members()
 	if err != nil {
-		return err
+		logger.Log.Error(err)
 	}
-	for i := 0; i < len(members); i++ {
+	return members
+}
 
-		err = r.two(ctx, members[i])
-		if err != nil {
-			return err
-		}
-		r.state = state.INPUT_LIE_TEXT
-		err = r.input(ctx)
-		if err != nil {
-			return err
-		}
-		err = r.three(ctx, members, members[i])
-		if err != nil {
-			return err
+// schedule arms the phase timer, a stale timer from an earlier phase is ignored
+func (r *Game) schedule(timeWait int) {
+	r.scheduleIn(time.Duration(int64(timeWait)) * time.Second)
+}
+
+func (r *Game) scheduleIn(d time.Duration) {
+	r.stopTimer()
+	for _, p := range r.players {
+		p.skip = false // skip votes count for a single phase
+	}
+	r.arm(d, r.advance)
+	r.checkpoint()
+}
+
+// arm runs fn on the game goroutine once d elapses unless the timer is stopped first
+func (r *Game) arm(d time.Duration, fn func()) {
+	r.stopTimer()
+	r.deadline = time.Now().Add(d)
+	seq := r.seq
+	r.timer = time.AfterFunc(d, func() {
+		r.send(func() {
+			if seq == r.seq {
+				fn()
+			}
+		})
+	})
+}
+
+func (r *Game) stopTimer() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.seq++
+	r.deadline = time.Time{}
+}
+
+// stamp adds the server clock and the phase deadline so clients can count down
+// against the same deadline the server enforces
+func (r *Game) stamp(msg *Message) *Message {
+	msg.Now = GetMillis(time.Now())
+	if !r.deadline.IsZero() && !r.paused {
+		msg.Deadline = GetMillis(r.deadline)
+	}
+	return msg
+}
+
+func (r *Game) broadcastState(msg *Message) error {
+	return r.messenger.Broadcast("onState", r.stamp(msg))
+}
+
+// advance moves the match to the phase that follows the current one
+func (r *Game) advance() {
+	r.touch()
+	var err error
+	switch r.state {
+	case state.WAITING:
+		if r.counting {
+			r.launch()
 		}
-		r.state = state.INPUT_TRUE_OPTION
-		err = r.input(ctx)
-		if err != nil {
-			return err
+	case state.STARTING:
+		err = r.one()
+		if err == nil && r.nextTurn() {
+			err = r.nextQuestion()
+		} else if err == nil {
+			r.complete()
 		}
-		r.state = state.SCORE
-		err = r.score(ctx, members, members[i])
-		if err != nil {
-			return err
+	case state.ROUND:
+		err = r.two()
+	case state.TWO:
+		if r.isAbout() {
+			err = r.waitInput(state.INPUT_TRUTH)
+		} else {
+			err = r.waitInput(state.INPUT_LIE_TEXT)
 		}
-		r.state = state.FINISH
-		err := r.finish(ctx)
-		if err != nil {
-			return err
+	case state.INPUT_TRUTH:
+		err = r.afterTruth()
+	case state.INPUT_LIE_TEXT:
+		r.resetPlayerReadiness()
+		err = r.three()
+	case state.THREE:
+		err = r.waitInput(state.INPUT_TRUE_OPTION)
+	case state.INPUT_TRUE_OPTION:
+		err = r.score() // scoring only counts players that picked an answer
+		r.resetPlayerReadiness()
+	case state.SCORE:
+		err = r.finish()
+	case state.FINISH:
+		r.turn++
+		if r.nextTurn() {
+			err = r.nextQuestion()
+		} else if r.settings.Final {
+			err = r.finalIntro()
+		} else {
+			r.complete()
 		}
+	case state.FINAL:
+		err = r.waitInput(state.INPUT_FINAL_LIE_TEXT)
+	case state.INPUT_FINAL_LIE_TEXT:
+		r.resetPlayerReadiness()
+		err = r.finalThree()
+	case state.FINAL_THREE:
+		err = r.waitInput(state.INPUT_FINAL_OPTION)
+	case state.INPUT_FINAL_OPTION, state.FINAL_REVEAL:
+		err = r.finalReveal() // readiness tells who picked an answer until the final is scored
+	case state.FINAL_SCORE:
+		r.complete()
+	default:
+		logger.Log.Errorf("unknown state %s", r.state)
 	}
+	if err != nil {
+		logger.Log.Infof("loop aborted: %s", err)
+		r.abort()
+	}
+}
 
+// complete records the finished match and returns the room to WAITING
+func (r *Game) complete() {
 	logger.Log.Info("stop loop")
+	winner := ""
+	if uid := GetLeaderId(r.players); uid != "" {
+		winner = r.players[uid].name
+		r.players[uid].seriesWins++
+	}
+	for _, p := range r.players {
+		p.seriesScore += p.totalScore
+	}
+	r.saveFinished(models.COMPLETED, winner)
+	r.played = true // the lobby may vote for a rematch now
 	r.restart()
-	return nil
+	if r.draining {
+		r.shutdown(game.DRAINING)
+	}
 }
 
-func (r *Game) starting(ctx context.Context) error {
-	r.state = state.STARTING
-	timeWait := 5
-
-	err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
+// abort broadcasts the scores so far and returns the room to WAITING
+func (r *Game) abort() {
+	r.stopTimer()
+	total := make(map[string]int)
+	for uid, p := range r.players {
+		total[uid] = p.totalScore
+	}
+	r.setState(state.ABORTED)
+	err := r.broadcastState(&Message{
 		State: r.state,
-		Ticks: timeWait,
+		Total: total,
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	winner := ""
+	if uid := GetLeaderId(r.players); uid != "" {
+		winner = r.players[uid].name
+	}
+	r.saveFinished(models.ABORTED, winner)
+	r.restart()
+	if r.draining {
+		r.shutdown(game.DRAINING)
+	}
+}
+
+func (r *Game) starting() error {
+	r.setState(state.STARTING)
+	timeWait := r.settings.Starting
+
+	r.schedule(timeWait)
+	err := r.broadcastState(&Message{
+		State:      r.state,
+		Ticks:      timeWait,
+		Round:      1,
+		Multiplier: GetRoundPlan(r.settings, GetPlayerCount(r.players))[0].Multiplier,
 	})
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
 	return nil
 }
 
-func (r *Game) one(ctx context.Context, members []string) error {
-	var questions []models.Question
-	r.db.Where("lang_code = ?", "ru").Find(&questions)
-	if len(questions) == 0 {
-		return errors.New("no questions")
+func (r *Game) one() error {
+	r.setState(state.ONE)
+	members, err := r.messenger.Members()
+	if err != nil {
+		return err
 	}
-	for _, uid := range members {
-		questionsCount := len(questions)
-		var ri int64
-		randIdx, err := rand.Int(rand.Reader, big.NewInt(int64(questionsCount)))
-		if err != nil {
-			logger.Log.Error(err)
-			ri = 0
-		} else {
-			ri = randIdx.Int64()
-		}
-		r.players[uid].question = &Question{
-			Question: questions[int(ri)].Question,
-			Answer:   questions[int(ri)].Answer,
+	r.lineup = GetPlayerIds(r.players, members)
+	if len(r.lineup) == 0 {
+		return errors.New("no players")
+	}
+	r.plan = GetRoundPlan(r.settings, len(r.lineup))
+	r.turns = nil
+	r.rounds = nil
+	for i, round := range r.plan {
+		for q := 0; q < round.Questions; q++ {
+			r.turns = append(r.turns, r.lineup[len(r.turns)%len(r.lineup)]) // the questions go round the lineup
+			r.rounds = append(r.rounds, i)
 		}
-		questions = services.RemoveQuestions(questions, int(ri))
 	}
+	r.turn = 0
+	r.round = -1
+	questions, err := r.questions()
+	if err != nil {
+		return err
+	}
+	if len(questions) < len(r.turns) {
+		return errors.New("no questions")
+	}
+	r.pool = questions
 
 	return nil
 }
 
-func (r *Game) input(ctx context.Context) error {
-	defer func() {
-		logger.Log.Info("stop waiting for input")
-	}()
+// drawQuestion hands the player a random question nobody got in this match yet
+func (r *Game) drawQuestion(uid string) error {
+	questionsCount := len(r.pool)
+	if questionsCount == 0 {
+		return errors.New("no questions")
+	}
+	var ri int64
+	randIdx, err := rand.Int(rand.Reader, big.NewInt(int64(questionsCount)))
+	if err != nil {
+		logger.Log.Error(err)
+		ri = 0
+	} else {
+		ri = randIdx.Int64()
+	}
+	r.players[uid].question = &Question{
+		Question:   r.pool[int(ri)].Question,
+		Answer:     r.pool[int(ri)].Answer,
+		Alternates: services.SplitAlternateSpellings(r.pool[int(ri)].AlternateSpellings),
+	}
+	if r.isAbout() {
+		r.players[uid].question.Question = GetAboutQuestion(r.pool[int(ri)].Question, r.players[uid].name)
+	}
+	r.pool = services.RemoveQuestions(r.pool, int(ri))
+	return nil
+}
 
-	timeWait := 30
-	err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
+func (r *Game) waitInput(phase string) error {
+	r.setState(phase)
+	timeWait := r.settings.Input
+	r.schedule(timeWait)
+	err := r.broadcastState(&Message{
 		State: r.state,
 		Ticks: timeWait,
 	})
@@ -365,107 +1248,148 @@ func (r *Game) input(ctx context.Context) error {
 		return err
 	}
 	logger.Log.Info("start waiting for input")
+	return nil
+}
 
-	ticker := time.NewTicker(1 * time.Second)
-	timeout := time.After(time.Duration(int64(timeWait)) * time.Second)
-	members, err := pitaya.GroupMembers(ctx, r.groupUuid)
-	if err != nil {
-		return err
+// nextQuestion asks the question of the current turn, introducing its round first
+// unless it is the first one which STARTING introduces
+func (r *Game) nextQuestion() error {
+	round := 0
+	if r.turn < len(r.rounds) {
+		round = r.rounds[r.turn]
 	}
-loop:
-	for {
-		select {
-		case <-r.done:
-			return errors.New("interupted")
-		case <-timeout:
-			break loop
-		case <-ticker.C:
-			if ArePlayersReady(r.players, members) {
-				break loop
-			}
-		}
+	if round == r.round {
+		return r.two()
 	}
-	err = r.resetPlayerReadiness(ctx, members)
+	r.round = round
+	if round == 0 {
+		return r.two()
+	}
+	return r.roundIntro()
+}
+
+// roundIntro tells the players a new round starts and what its points are worth
+func (r *Game) roundIntro() error {
+	r.setState(state.ROUND)
+	timeWait := r.settings.Starting
+
+	r.schedule(timeWait)
+	err := r.broadcastState(&Message{
+		State:      r.state,
+		Ticks:      timeWait,
+		Round:      r.round + 1,
+		Multiplier: r.multiplier(),
+	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *Game) changeCurrentPlayer(ctx context.Context) error {
-	currentPlayerId := GetCurrentPlayerId(r.players)
-	if currentPlayerId != "" {
-		r.players[currentPlayerId].current = false
+// multiplier returns what the points of the current round are multiplied by
+func (r *Game) multiplier() int {
+	if r.round < 0 || r.round >= len(r.plan) {
+		return 1
 	}
+	return r.plan[r.round].Multiplier
+}
 
-	members, err := pitaya.GroupMembers(ctx, r.groupUuid)
+func (r *Game) two() error {
+	r.setState(state.TWO)
+	currentPlayerId := r.turns[r.turn]
+	err := r.drawQuestion(currentPlayerId)
 	if err != nil {
 		return err
 	}
-	for _, uid := range members {
-		if r.players[uid].used {
-			continue
-		}
-		currentPlayerId = uid
-		break
-	}
-
-	if currentPlayerId != "" {
-		r.players[currentPlayerId].used = true
-		r.players[currentPlayerId].current = true
+	for uid, p := range r.players {
+		p.current = r.isAbout() && uid == currentPlayerId
 	}
 
-	return nil
-}
-
-func (r *Game) two(ctx context.Context, currentPlayerId string) error {
-
 	other := &Question{
 		Question: r.players[currentPlayerId].question.Question,
 	}
+	r.other = other
+	r.answers = nil
 
-	timeWait := 5
+	timeWait := r.settings.Two
 
-	err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
-		State: r.state,
-		Other: other,
-		Ticks: timeWait,
+	r.schedule(timeWait)
+	err = r.broadcastState(&Message{
+		CurrentPlayerId: currentPlayerId,
+		State:           r.state,
+		Other:           other,
+		Ticks:           timeWait,
+		Round:           r.round + 1,
+		Multiplier:      r.multiplier(),
 	})
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
 
 	return nil
 }
 
-func (r *Game) resetPlayerReadiness(ctx context.Context, members []string) error {
-	for _, uid := range members {
-		r.players[uid].ready = false
+func (r *Game) resetPlayerReadiness() {
+	logger.Log.Info("stop waiting for input")
+	for _, p := range r.players {
+		p.ready = false
 	}
-	return nil
 }
 
-func (r *Game) three(ctx context.Context, members []string, currentPlayerId string) error {
+func (r *Game) three() error {
+	r.setState(state.THREE)
+	currentPlayerId := r.turns[r.turn]
+	r.answers = r.shuffleAnswers(r.players[currentPlayerId].question)
+	timeWait := r.settings.Three
+	r.schedule(timeWait)
+	err := r.broadcastState(&Message{
+		State:   r.state,
+		Answers: r.answers,
+		Ticks:   timeWait,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
 
+// shuffleAnswers mixes the lies of the lineup with the truth and records where each one ended up,
+// players that wrote the same lie share a single answer
+func (r *Game) shuffleAnswers(truth *Question) []string {
 	type AnswerShuffled struct {
 		Text string
-		Id   string
+		Ids  []string
+	}
+	for _, p := range r.players {
+		p.shuffledAnswerIdx = -1 // players left out of the match own no answer
 	}
 	var lieAnswersShuffled []*AnswerShuffled
-	for _, uid := range members {
+	lies := make(map[string]*AnswerShuffled)
+	for _, uid := range r.lineup {
+		if r.players[uid].current {
+			continue // the named player wrote the truth
+		}
 		answer := r.players[uid].answerLie
 		if answer == "" {
 			answer = fmt.Sprintf("%s's lie", r.players[uid].name) // if player missed answer in round 2 return random
 		}
-		lieAnswersShuffled = append(lieAnswersShuffled, &AnswerShuffled{
+		key := services.NormalizeAnswer(answer)
+		if key == "" {
+			key = strings.ToLower(answer)
+		}
+		if lie, ok := lies[key]; ok {
+			lie.Ids = append(lie.Ids, uid)
+			continue
+		}
+		lies[key] = &AnswerShuffled{
 			Text: answer,
-			Id:   uid,
-		})
+			Ids:  []string{uid},
+		}
+		lieAnswersShuffled = append(lieAnswersShuffled, lies[key])
 	}
 	lieAnswersShuffled = append(lieAnswersShuffled, &AnswerShuffled{
-		Text: r.players[currentPlayerId].question.Answer,
-		Id:   "truth",
+		Text: truth.Answer,
 	})
 	mathRand.Seed(time.Now().UnixNano())
 	mathRand.Shuffle(len(lieAnswersShuffled), func(i, j int) {
@@ -473,31 +1397,21 @@ func (r *Game) three(ctx context.Context, members []string, currentPlayerId stri
 	})
 	var lieAnswers []string
 	for i := 0; i < len(lieAnswersShuffled); i++ {
-		if lieAnswersShuffled[i].Id == "truth" {
-			r.players[currentPlayerId].question.ShuffledAnswerIdx = i
-		} else {
-			r.players[lieAnswersShuffled[i].Id].shuffledAnswerIdx = i
+		if lieAnswersShuffled[i].Ids == nil {
+			truth.ShuffledAnswerIdx = i
+		}
+		for _, uid := range lieAnswersShuffled[i].Ids {
+			r.players[uid].shuffledAnswerIdx = i
 		}
 		lieAnswers = append(lieAnswers, lieAnswersShuffled[i].Text)
 	}
-	timeWait := 5
-	err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
-		State:   r.state,
-		Answers: lieAnswers,
-		Ticks:   timeWait,
-	})
-	if err != nil {
-		return err
-	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
-
-	//time.Sleep(5 * time.Second)
-
-	return nil
+	return lieAnswers
 }
 
-func (r *Game) score(ctx context.Context, members []string, currentPlayerId string) error {
-	scoreMap := GetPlayersScoreV2(r.players, currentPlayerId)
+func (r *Game) score() error {
+	r.setState(state.SCORE)
+	currentPlayerId := r.turns[r.turn]
+	scoreMap := GetPlayersScoreV2(r.players, currentPlayerId, r.multiplier(), r.settings.Fooled)
 
 	finalScore := make(map[string]int)
 	for uid, score := range scoreMap {
@@ -507,13 +1421,14 @@ func (r *Game) score(ctx context.Context, members []string, currentPlayerId stri
 
 	answermatrix := GetAnswersMatrix(r.players, currentPlayerId)
 
-	for _, uid := range members {
+	for _, uid := range r.lineup {
 		r.players[uid].answerLie = ""
 		r.players[uid].answerTruthId = 0
 	}
 
-	timeWait := 10
-	err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
+	timeWait := r.settings.Score
+	r.schedule(timeWait)
+	err := r.broadcastState(&Message{
 		State:   r.state,
 		Score:   scoreMap,
 		Total:   finalScore,
@@ -523,21 +1438,24 @@ func (r *Game) score(ctx context.Context, members []string, currentPlayerId stri
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
-	//time.Sleep(10 * time.Second)
 	return nil
 }
 
-func (r *Game) finish(ctx context.Context) error {
-	timeWait := 5
+func (r *Game) finish() error {
+	r.setState(state.FINISH)
+	for _, p := range r.players {
+		p.current = false
+	}
+	timeWait := r.settings.Finish
 
-	err := pitaya.GroupBroadcast(ctx, "game", r.groupUuid, "onState", &Message{
-		State: r.state,
-		Ticks: timeWait,
+	r.schedule(timeWait)
+	err := r.broadcastState(&Message{
+		State:  r.state,
+		Ticks:  timeWait,
+		Series: GetSeries(r.players),
 	})
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Duration(int64(timeWait)) * time.Second)
 	return nil
 }
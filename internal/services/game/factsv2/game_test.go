@@ -0,0 +1,1058 @@
+package factsv2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"github.com/topfreegames/pitaya/cluster"
+	"github.com/topfreegames/pitaya/route"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	errors2 "github.com/zdarovich/fibbage-game-server/internal/errors"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMessenger struct {
+	mu         sync.Mutex
+	members    map[string]bool
+	broadcasts []string
+	states     []*Message
+	pushes     map[string][]string
+}
+
+func newFakeMessenger() *fakeMessenger {
+	return &fakeMessenger{
+		members: make(map[string]bool),
+		pushes:  make(map[string][]string),
+	}
+}
+
+func (m *fakeMessenger) Broadcast(route string, v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.broadcasts = append(m.broadcasts, route)
+	if msg, ok := v.(*Message); ok && route == "onState" {
+		m.states = append(m.states, msg)
+	}
+	return nil
+}
+
+func (m *fakeMessenger) Push(uid, route string, v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pushes[uid] = append(m.pushes[uid], route)
+	return nil
+}
+
+func (m *fakeMessenger) AddMember(uid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[uid] = true
+	return nil
+}
+
+func (m *fakeMessenger) RemoveMember(uid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.members, uid)
+	return nil
+}
+
+func (m *fakeMessenger) Members() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var uids []string
+	for uid := range m.members {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	return uids, nil
+}
+
+func (m *fakeMessenger) count(route string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, r := range m.broadcasts {
+		if r == route {
+			n++
+		}
+	}
+	return n
+}
+
+type fakeQuestionStore struct{}
+
+func (fakeQuestionStore) Questions(langCode string, familyFriendly bool) ([]models.Question, error) {
+	var questions []models.Question
+	for i := 0; i < 20; i++ {
+		q := models.Question{
+			Question:           fmt.Sprintf("question %d", i),
+			Answer:             fmt.Sprintf("answer %d", i),
+			AlternateSpellings: fmt.Sprintf("reply %d,response %d", i, i),
+		}
+		q.ID = uint(i + 1)
+		questions = append(questions, q)
+	}
+	return questions, nil
+}
+
+func (fakeQuestionStore) Finals(langCode string, familyFriendly bool) ([]models.Question, error) {
+	q := models.Question{
+		Question: "final question",
+		Answer:   "final answer",
+		Final:    true,
+	}
+	q.ID = 100
+	return []models.Question{q}, nil
+}
+
+type fakeTemplateStore struct{}
+
+func (fakeTemplateStore) Templates(langCode string, familyFriendly bool) ([]models.QuestionTemplate, error) {
+	var templates []models.QuestionTemplate
+	for i := 0; i < 20; i++ {
+		q := models.QuestionTemplate{
+			Template: fmt.Sprintf("<PLAYER> template %d <BLANK>", i),
+		}
+		q.ID = uint(i + 1)
+		templates = append(templates, q)
+	}
+	return templates, nil
+}
+
+func newTestGame(t *testing.T) (*Game, *fakeMessenger) {
+	m := newFakeMessenger()
+	r := New("TEST", nil, DefaultRoomSettings())
+	r.exec(func() {
+		r.messenger = m
+		r.store = fakeQuestionStore{}
+		r.templates = fakeTemplateStore{}
+	})
+	return r, m
+}
+
+func joinPlayers(t *testing.T, r *Game, n int) []string {
+	var uids []string
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		uid := fmt.Sprintf("player%d", i)
+		uids = append(uids, uid)
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			r.exec(func() {
+				res, err := r.join(uid, uid)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, "success", res.Result)
+			})
+		}(uid)
+	}
+	wg.Wait()
+	return uids
+}
+
+func currentState(r *Game) string {
+	var s string
+	r.exec(func() {
+		s = r.state
+	})
+	return s
+}
+
+func TestGameConcurrentJoin(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 8)
+
+	members, _ := m.Members()
+	assert.Equal(t, len(uids), len(members))
+	hosts := 0
+	r.exec(func() {
+		for _, p := range r.players {
+			if p.host {
+				hosts++
+			}
+		}
+	})
+	assert.Equal(t, 1, hosts)
+}
+
+func TestGameConcurrentInput(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 6)
+
+	r.exec(func() {
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+	})
+	assert.Equal(t, state.INPUT_LIE_TEXT, currentState(r))
+
+	var wg sync.WaitGroup
+	for _, uid := range uids {
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			r.exec(func() {
+				_, _ = r.input(uid, &InputMessage{Answer: "lie " + uid})
+			})
+		}(uid)
+	}
+	wg.Wait()
+	assert.Equal(t, state.THREE, currentState(r))
+	assert.Equal(t, len(uids), m.count("onReady"))
+}
+
+func TestGameConcurrentDisconnect(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 6)
+	emptied := make(chan struct{})
+	r.exec(func() {
+		r.onEmpty = func() {
+			close(emptied)
+		}
+		r.launch()
+	})
+
+	var wg sync.WaitGroup
+	for _, uid := range uids {
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			r.onClose(uid)()
+		}(uid)
+	}
+	wg.Wait()
+	<-emptied
+
+	members, _ := m.Members()
+	assert.Equal(t, 0, len(members))
+	assert.Equal(t, false, r.exec(func() {}))
+}
+
+func TestGameStaleTimer(t *testing.T) {
+	r, _ := newTestGame(t)
+	joinPlayers(t, r, 3)
+
+	var seq int
+	r.exec(func() {
+		r.launch()
+		seq = r.seq
+		r.advance() // STARTING -> TWO re-arms the timer
+	})
+	r.exec(func() {
+		if seq == r.seq {
+			r.advance()
+		}
+	})
+	assert.Equal(t, state.TWO, currentState(r))
+}
+
+func TestGamePauseResume(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	host := ""
+	r.exec(func() {
+		host = GetHostId(r.players)
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+	})
+	var guest string
+	for _, uid := range uids {
+		if uid != host {
+			guest = uid
+		}
+	}
+
+	r.exec(func() {
+		assert.Equal(t, "fail", r.pause(guest).Result)
+		assert.Equal(t, "success", r.pause(host).Result)
+		assert.Equal(t, "fail", r.pause(host).Result)
+		assert.Equal(t, 30, r.remainingTicks())
+		res, _ := r.input(guest, &InputMessage{Answer: "lie"})
+		assert.Equal(t, "fail", res.Result)
+	})
+	assert.Equal(t, state.INPUT_LIE_TEXT, currentState(r))
+
+	r.exec(func() {
+		assert.Equal(t, "success", r.resume(host).Result)
+		assert.Equal(t, "fail", r.resume(host).Result)
+		assert.Equal(t, 30, r.remainingTicks())
+		res, _ := r.input(guest, &InputMessage{Answer: "lie"})
+		assert.Equal(t, "success", res.Result)
+	})
+	assert.Equal(t, 1, m.count("onReady"))
+}
+
+func TestGameHostLeavesSpectators(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 1)
+
+	r.exec(func() {
+		res, err := r.spectate("spectator", "spectator")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "success", res.Result)
+		r.disconnect(uids[0])
+		assert.Equal(t, "", GetHostId(r.players))
+
+		res, err = r.join("newcomer", "newcomer")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "success", res.Result)
+		assert.Equal(t, "newcomer", GetHostId(r.players))
+		assert.Equal(t, "fail", r.start(uids[0]).Result)
+	})
+	assert.Equal(t, 0, m.count("onHostChanged"))
+}
+
+func TestGamePausedDisconnect(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		host := GetHostId(r.players)
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		var last string
+		for _, uid := range uids {
+			if uid == host || last != "" {
+				r.input(uid, &InputMessage{Answer: "lie " + uid})
+			} else {
+				last = uid
+			}
+		}
+		assert.Equal(t, "success", r.pause(host).Result)
+		r.disconnect(last)
+		assert.Equal(t, state.INPUT_LIE_TEXT, r.state)
+		assert.Equal(t, true, r.deadline.IsZero())
+
+		assert.Equal(t, "success", r.resume(host).Result)
+		assert.Equal(t, state.THREE, r.state)
+	})
+}
+
+func TestGamePausedKick(t *testing.T) {
+	r, _ := newTestGame(t)
+	joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		host := GetHostId(r.players)
+		r.launch()
+		r.advance() // STARTING -> TWO
+		if r.turns[r.turn] == host {
+			r.turn++
+		}
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		current := r.turns[r.turn]
+		assert.Equal(t, "success", r.pause(host).Result)
+		assert.Equal(t, "success", r.kick(host, current, false).Result)
+		assert.Equal(t, state.INPUT_LIE_TEXT, r.state)
+		assert.Equal(t, true, r.deadline.IsZero())
+
+		assert.Equal(t, "success", r.resume(host).Result)
+		assert.Equal(t, state.FINISH, r.state)
+		assert.Equal(t, false, r.paused)
+		r.advance() // FINISH -> TWO
+		assert.Equal(t, state.TWO, r.state)
+		assert.NotEqual(t, current, r.turns[r.turn])
+	})
+}
+
+func TestGameJoinFreesSeats(t *testing.T) {
+	r, _ := newTestGame(t)
+	r.exec(func() {
+		r.settings.MaxPlayers = 3
+	})
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		res, _ := r.join("late", "late")
+		assert.Equal(t, errors2.ROOM_FULL, res.Error)
+
+		r.disconnect(uids[0])
+		r.disconnect(uids[1])
+		res, _ = r.join("late", "late")
+		assert.Equal(t, "success", res.Result)
+		assert.Equal(t, 2, GetPlayerCount(r.players))
+		_, ok := r.players[uids[0]]
+		assert.Equal(t, false, ok)
+	})
+}
+
+func TestGameKick(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	var host, current, other string
+	r.exec(func() {
+		host = GetHostId(r.players)
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		current = r.turns[r.turn]
+	})
+	for _, uid := range uids {
+		if uid != host && uid != current {
+			other = uid
+		}
+	}
+
+	var token string
+	r.exec(func() {
+		token = r.players[other].token
+		assert.Equal(t, "fail", r.kick(other, host, false).Result)
+		assert.Equal(t, "fail", r.kick(host, host, false).Result)
+		assert.Equal(t, "success", r.kick(host, other, true).Result)
+		_, ok := r.players[other]
+		assert.Equal(t, false, ok)
+		for _, uid := range r.turns {
+			assert.NotEqual(t, other, uid)
+		}
+	})
+	assert.Equal(t, 1, m.count("onPlayerKicked"))
+
+	r.exec(func() {
+		res, _ := r.join(other, other)
+		assert.Equal(t, "BANNED", res.Error)
+		res, _ = r.rejoin(nil, nil, token)
+		assert.Equal(t, "BANNED", res.Error)
+	})
+}
+
+func TestGameKickCurrentPlayer(t *testing.T) {
+	r, _ := newTestGame(t)
+	joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		host := GetHostId(r.players)
+		r.launch()
+		r.advance() // STARTING -> TWO
+		current := r.turns[r.turn]
+		if current == host {
+			r.turn++
+			current = r.turns[r.turn]
+		}
+		next := ""
+		if r.turn+1 < len(r.turns) {
+			next = r.turns[r.turn+1]
+		}
+		assert.Equal(t, "success", r.kick(host, current, false).Result)
+		assert.Equal(t, state.FINISH, r.state)
+		r.advance()
+		if next != "" {
+			assert.Equal(t, next, r.turns[r.turn])
+			assert.Equal(t, state.TWO, r.state)
+		}
+	})
+}
+
+func TestGamePlayerLimits(t *testing.T) {
+	r, _ := newTestGame(t)
+	r.exec(func() {
+		r.settings.MaxPlayers = 14
+	})
+	joinPlayers(t, r, 1)
+
+	r.exec(func() {
+		host := GetHostId(r.players)
+		assert.Equal(t, "NOT_ENOUGH_PLAYERS", r.start(host).Error)
+	})
+
+	uids := joinPlayers(t, r, 14) // player0 joined twice, icons run out after 12
+	r.exec(func() {
+		assert.Equal(t, 14, GetPlayerCount(r.players))
+		for _, uid := range uids {
+			assert.NotEqual(t, "", r.players[uid].iconName)
+		}
+		res, _ := r.join("late", "late")
+		assert.Equal(t, "ROOM_FULL", res.Error)
+	})
+}
+
+func TestGameDisconnectMidRound(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	var gone string
+	r.exec(func() {
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		gone = r.turns[len(r.turns)-1]
+		r.players[gone].totalScore = 700
+	})
+	r.onClose(gone)()
+
+	r.exec(func() {
+		for _, uid := range uids {
+			if uid != gone {
+				_, _ = r.input(uid, &InputMessage{Answer: "lie " + uid})
+			}
+		}
+		assert.Equal(t, state.THREE, r.state)
+		r.advance() // THREE -> INPUT_TRUE_OPTION
+		for _, uid := range uids {
+			if uid != gone {
+				id := 0
+				for id == r.players[uid].shuffledAnswerIdx {
+					id++
+				}
+				_, _ = r.input(uid, &InputMessage{AnswerId: id})
+			}
+		}
+		assert.Equal(t, state.SCORE, r.state)
+		assert.Equal(t, true, r.players[gone].totalScore >= 700)
+
+		for r.state != state.WAITING {
+			if r.turn < len(r.turns) { // the final round has no turns
+				assert.NotEqual(t, gone, r.turns[r.turn])
+			}
+			r.advance()
+		}
+	})
+}
+
+func TestGameReap(t *testing.T) {
+	r, m := newTestGame(t)
+	joinPlayers(t, r, 3)
+	expiry := Expiry{Idle: time.Minute, Hard: time.Hour, Warning: 10 * time.Second}
+	var left []string
+	var activeAt time.Time
+	r.exec(func() {
+		r.onLeave = func(uid string) {
+			left = append(left, uid)
+		}
+		activeAt = r.activeAt
+	})
+
+	r.send(func() {
+		r.reap(activeAt.Add(30*time.Second), expiry)
+		r.reap(activeAt.Add(55*time.Second), expiry)
+		r.reap(activeAt.Add(56*time.Second), expiry)
+	})
+	r.exec(func() {})
+	assert.Equal(t, 1, m.count("onRoomClosing"))
+
+	r.send(func() {
+		r.reap(time.Now().Add(time.Minute), expiry)
+	})
+	assert.Equal(t, false, r.exec(func() {}))
+	assert.Equal(t, 1, m.count("onRoomClosed"))
+	assert.Equal(t, 3, len(left))
+	members, _ := m.Members()
+	assert.Equal(t, 0, len(members))
+}
+
+func TestGameHasKeepsIdle(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 1)
+	idle := time.Now().Add(-time.Hour)
+	r.exec(func() {
+		r.activeAt = idle
+	})
+
+	assert.Equal(t, true, r.Has(uids[0]))
+	r.exec(func() {
+		assert.Equal(t, idle, r.activeAt)
+	})
+}
+
+func TestGameLobbyCountdown(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		for _, uid := range uids {
+			assert.Equal(t, "success", r.ready(uid).Result)
+		}
+		assert.Equal(t, true, r.counting)
+		assert.Equal(t, "success", r.ready(uids[0]).Result) // un-ready
+		assert.Equal(t, false, r.counting)
+		assert.Equal(t, "success", r.ready(uids[0]).Result)
+		assert.Equal(t, true, r.counting)
+	})
+	r.onClose(uids[1])()
+	r.exec(func() {
+		assert.Equal(t, false, r.counting)
+		assert.Equal(t, state.WAITING, r.state)
+		assert.Equal(t, "success", r.ready(uids[2]).Result)
+		assert.Equal(t, "success", r.ready(uids[2]).Result)
+		assert.Equal(t, true, r.counting)
+		r.advance() // countdown elapsed
+		assert.Equal(t, state.STARTING, r.state)
+		assert.Equal(t, false, r.players[uids[0]].ready)
+		assert.Equal(t, "fail", r.ready(uids[0]).Result)
+	})
+	assert.Equal(t, 2, m.count("onCountdownCancelled"))
+}
+
+func TestGameCompleteSeries(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.launch()
+		r.players[uids[0]].totalScore = 500
+		r.complete()
+	})
+	last := m.states[len(m.states)-1]
+	assert.Equal(t, state.WAITING, last.State)
+	assert.Equal(t, &SeriesRow{Score: 500, Wins: 1}, last.Series[uids[0]])
+	assert.Equal(t, &SeriesRow{Score: 0, Wins: 0}, last.Series[uids[1]])
+}
+
+func TestGameRematch(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		assert.Equal(t, "fail", r.rematch(uids[0]).Result)
+		r.launch()
+		for r.state != state.WAITING {
+			r.advance()
+		}
+		for _, uid := range uids {
+			r.players[uid].seriesScore = 100
+		}
+		assert.Equal(t, true, r.played)
+		assert.Equal(t, "success", r.rematch(uids[0]).Result)
+		assert.Equal(t, state.WAITING, r.state)
+		assert.Equal(t, "success", r.rematch(uids[1]).Result)
+		assert.Equal(t, state.STARTING, r.state)
+		assert.Equal(t, false, r.played)
+		assert.Equal(t, 100, GetSeries(r.players)[uids[2]].Score)
+	})
+	assert.Equal(t, 2, m.count("onRematchVote"))
+}
+
+func TestGameRounds(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.settings.Rounds = 2
+		r.launch()
+		r.advance() // STARTING -> TWO
+		assert.Equal(t, 2*len(uids), len(r.turns))
+		assert.Equal(t, len(uids), len(r.lineup))
+		questions := 0
+		for r.state != state.WAITING {
+			if r.state == state.TWO {
+				questions++
+			}
+			r.advance()
+		}
+		assert.Equal(t, 2*len(uids), questions)
+	})
+}
+
+func TestGameRoundPlan(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.settings.Final = false
+		r.settings.Plan = []Round{{Questions: 2}, {Questions: 4, Multiplier: 2}}
+		r.launch()
+		r.advance() // STARTING -> TWO
+		assert.Equal(t, state.TWO, r.state)
+		assert.Equal(t, []int{0, 0, 1, 1, 1, 1}, r.rounds)
+		questions, intros := 0, 0
+		totals := make(map[string]int)
+		for r.state != state.WAITING {
+			switch r.state {
+			case state.TWO:
+				questions++
+			case state.ROUND:
+				intros++
+				assert.Equal(t, 1, r.round)
+				assert.Equal(t, 2, r.multiplier())
+			case state.INPUT_TRUE_OPTION:
+				truthIdx := r.players[r.turns[r.turn]].question.ShuffledAnswerIdx
+				for _, uid := range uids {
+					r.players[uid].answerTruthId = truthIdx
+					r.players[uid].ready = true
+				}
+			case state.SCORE:
+				for _, uid := range uids {
+					totals[uid] = r.players[uid].totalScore
+				}
+			}
+			r.advance()
+		}
+		assert.Equal(t, 6, questions)
+		assert.Equal(t, 1, intros)
+		for _, uid := range uids {
+			assert.Equal(t, 2*1000+4*2000, totals[uid])
+		}
+	})
+}
+
+func TestGameLieIsTruth(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		truth := r.players[r.turns[r.turn]].question
+		assert.Equal(t, 2, len(truth.Alternates))
+
+		res, _ := r.input(uids[0], &InputMessage{Answer: " The " + strings.ToUpper(truth.Answer) + "!"})
+		assert.Equal(t, errors2.LIE_IS_TRUTH, res.Error)
+		res, _ = r.input(uids[1], &InputMessage{Answer: truth.Alternates[1] + "s"})
+		assert.Equal(t, errors2.LIE_IS_TRUTH, res.Error)
+		assert.Equal(t, false, r.players[uids[0]].ready)
+
+		res, _ = r.input(uids[0], &InputMessage{Answer: "a made up answer"})
+		assert.Equal(t, "success", res.Result)
+	})
+}
+
+func TestGameSharedLie(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		r.input(uids[0], &InputMessage{Answer: "The Same Lie"})
+		r.input(uids[1], &InputMessage{Answer: "same lie."})
+		r.input(uids[2], &InputMessage{Answer: "another lie"})
+		assert.Equal(t, state.THREE, r.state)
+		assert.Equal(t, 3, len(r.answers))
+		assert.Equal(t, r.players[uids[0]].shuffledAnswerIdx, r.players[uids[1]].shuffledAnswerIdx)
+
+		r.advance() // THREE -> INPUT_TRUE_OPTION
+		res, _ := r.input(uids[1], &InputMessage{AnswerId: r.players[uids[0]].shuffledAnswerIdx})
+		assert.Equal(t, "fail", res.Result) // the shared lie is the player's own
+		r.input(uids[2], &InputMessage{AnswerId: r.players[uids[0]].shuffledAnswerIdx})
+		assert.Equal(t, state.INPUT_TRUE_OPTION, r.state)
+		r.advance() // INPUT_TRUE_OPTION -> SCORE
+		assert.Equal(t, 500, r.players[uids[0]].totalScore)
+		assert.Equal(t, 500, r.players[uids[1]].totalScore)
+	})
+}
+
+func TestGameInputDeadline(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		msg := r.stamp(&Message{})
+		assert.Equal(t, GetMillis(r.deadline), msg.Deadline)
+		assert.NotEqual(t, int64(0), msg.Now)
+
+		r.deadline = time.Now().Add(-time.Millisecond) // the timer has not fired yet
+		res, _ := r.input(uids[0], &InputMessage{Answer: "late"})
+		assert.Equal(t, "fail", res.Result)
+	})
+}
+
+func TestGameSkip(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		assert.Equal(t, "fail", r.skip(uids[0]).Result)
+		r.launch()
+		r.advance() // STARTING -> TWO
+		assert.Equal(t, "success", r.skip(uids[0]).Result)
+		assert.Equal(t, "fail", r.skip(uids[0]).Result)
+		assert.Equal(t, state.TWO, r.state)
+		assert.Equal(t, "success", r.skip(uids[1]).Result)
+		assert.Equal(t, state.INPUT_LIE_TEXT, r.state)
+		assert.Equal(t, "fail", r.skip(uids[2]).Result)
+		assert.Equal(t, false, r.players[uids[0]].skip)
+	})
+	assert.Equal(t, 2, m.count("onSkipVote"))
+}
+
+func TestGameDrain(t *testing.T) {
+	r, m := newTestGame(t)
+	joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.launch()
+		r.drain(time.Minute)
+		assert.Equal(t, state.STARTING, r.state)
+		for r.state != state.WAITING {
+			r.advance()
+		}
+	})
+	assert.Equal(t, false, r.exec(func() {}))
+	assert.Equal(t, 1, m.count("onServerNotice"))
+	assert.Equal(t, 1, m.count("onRoomClosed"))
+
+	idle, _ := newTestGame(t)
+	idle.send(func() {
+		idle.drain(time.Minute)
+	})
+	assert.Equal(t, false, idle.exec(func() {}))
+}
+
+func TestGameSnapshotRestore(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	var snapshot *Snapshot
+	r.exec(func() {
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_LIE_TEXT
+		for _, uid := range uids {
+			_, _ = r.input(uid, &InputMessage{Answer: "lie " + uid})
+		}
+		assert.Equal(t, state.THREE, r.state)
+		r.players[uids[0]].totalScore = 1500
+		snapshot = r.snapshot()
+	})
+	data, err := json.Marshal(snapshot)
+	assert.Equal(t, nil, err)
+	restored := GetRestorableSnapshot(&models.Room{Snapshot: string(data)})
+	assert.NotEqual(t, nil, restored)
+
+	g, m := newTestGame(t)
+	r.exec(func() {
+		g.exec(func() {
+			assert.Equal(t, nil, g.restore(restored))
+			assert.Equal(t, state.THREE, g.state)
+			assert.Equal(t, true, g.paused)
+			assert.Equal(t, r.lineup, g.lineup)
+			assert.Equal(t, r.turns, g.turns)
+			assert.Equal(t, r.answers, g.answers)
+			assert.Equal(t, len(r.pool), len(g.pool))
+			for uid, p := range r.players {
+				assert.Equal(t, p.totalScore, g.players[uid].totalScore)
+				assert.Equal(t, p.answerLie, g.players[uid].answerLie)
+				assert.Equal(t, p.shuffledAnswerIdx, g.players[uid].shuffledAnswerIdx)
+				assert.Equal(t, uid, g.tokens[p.token])
+				assert.Equal(t, false, g.players[uid].connected)
+			}
+			current := r.turns[r.turn]
+			assert.Equal(t, *r.players[current].question, *g.players[current].question)
+		})
+	})
+
+	g.exec(func() {
+		g.players[uids[0]].connected = true
+		g.checkRestored()
+		assert.Equal(t, true, g.paused)
+		assert.Equal(t, false, g.deadline.IsZero())
+
+		for _, uid := range uids {
+			g.players[uid].connected = true
+		}
+		g.checkRestored()
+		assert.Equal(t, false, g.paused)
+		assert.Equal(t, false, g.restored)
+		assert.Equal(t, state.THREE, g.state)
+	})
+	assert.Equal(t, 1, m.count("onState"))
+
+	assert.Equal(t, (*Snapshot)(nil), GetRestorableSnapshot(&models.Room{}))
+	assert.Equal(t, (*Snapshot)(nil), GetRestorableSnapshot(&models.Room{Snapshot: `{"state":"WAITING"}`}))
+}
+
+type fakeOwners struct {
+	rooms    map[string]string
+	draining map[string]bool
+}
+
+func (o *fakeOwners) Claim(code string) (bool, error) {
+	if _, ok := o.rooms[code]; ok {
+		return false, nil
+	}
+	o.rooms[code] = "self"
+	return true, nil
+}
+
+func (o *fakeOwners) Release(code string) error {
+	delete(o.rooms, code)
+	return nil
+}
+
+func (o *fakeOwners) Owner(code string) (string, error) {
+	return o.rooms[code], nil
+}
+
+func (o *fakeOwners) LeaseTTL() time.Duration {
+	return 0
+}
+
+func (o *fakeOwners) Lost() <-chan struct{} {
+	return nil
+}
+
+func (o *fakeOwners) SetDraining() error {
+	o.draining["self"] = true
+	return nil
+}
+
+func (o *fakeOwners) Draining() (map[string]bool, error) {
+	return o.draining, nil
+}
+
+func TestRoomRouter(t *testing.T) {
+	owners := &fakeOwners{
+		rooms:    map[string]string{"ABCD": "two"},
+		draining: map[string]bool{},
+	}
+	servers := map[string]*cluster.Server{
+		"one": {ID: "one", Type: "game"},
+		"two": {ID: "two", Type: "game"},
+	}
+	pick := NewRoomRouter(owners)
+
+	for i := 0; i < 10; i++ {
+		sv, err := pick(context.Background(), &route.Route{Service: "game", Method: "join"}, []byte(`{"uuid":"abcd"}`), servers)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "two", sv.ID)
+	}
+	sv, err := pick(context.Background(), &route.Route{Service: "game", Method: "join"}, []byte(`{"uuid":"WXYZ"}`), servers)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, servers[sv.ID])
+	sv, err = pick(context.Background(), &route.Route{Service: "game", Method: "create"}, nil, servers)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, servers[sv.ID])
+
+	owners.draining["two"] = true
+	for i := 0; i < 10; i++ {
+		sv, err = pick(context.Background(), &route.Route{Service: "game", Method: "create"}, nil, servers)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "one", sv.ID)
+	}
+	sv, err = pick(context.Background(), &route.Route{Service: "game", Method: "join"}, []byte(`{"uuid":"ABCD"}`), servers)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "two", sv.ID)
+	owners.draining["one"] = true
+	sv, err = pick(context.Background(), &route.Route{Service: "game", Method: "create"}, nil, servers)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, servers[sv.ID])
+}
+
+func TestGameFinalRound(t *testing.T) {
+	r, m := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.launch()
+		for r.state != state.FINAL {
+			r.advance()
+		}
+		assert.Equal(t, "final question", r.other.Question)
+		for _, uid := range uids {
+			r.players[uid].totalScore = 1000
+		}
+		r.advance() // FINAL -> INPUT_FINAL_LIE_TEXT
+		for _, uid := range uids {
+			res, _ := r.input(uid, &InputMessage{Answer: "lie " + uid})
+			assert.Equal(t, "success", res.Result)
+		}
+		assert.Equal(t, state.FINAL_THREE, r.state)
+		assert.Equal(t, len(uids)+1, len(r.answers))
+		r.advance() // FINAL_THREE -> INPUT_FINAL_OPTION
+
+		truth := r.final.ShuffledAnswerIdx
+		res, _ := r.input(uids[0], &InputMessage{AnswerId: truth, Wager: 1001})
+		assert.Equal(t, errors2.INVALID_WAGER, res.Error)
+		res, _ = r.input(uids[0], &InputMessage{AnswerId: truth, Wager: 400})
+		assert.Equal(t, "success", res.Result)
+		res, _ = r.input(uids[1], &InputMessage{AnswerId: r.players[uids[2]].shuffledAnswerIdx, Wager: 300})
+		assert.Equal(t, "success", res.Result)
+		res, _ = r.input(uids[2], &InputMessage{AnswerId: r.players[uids[1]].shuffledAnswerIdx})
+		assert.Equal(t, "success", res.Result)
+
+		assert.Equal(t, state.FINAL_REVEAL, r.state)
+		assert.Equal(t, 3, len(r.reveals))
+		assert.Equal(t, true, r.reveals[2].Truth)
+		assert.Equal(t, []string{uids[0]}, r.reveals[2].PickedIds)
+		for r.state == state.FINAL_REVEAL {
+			r.advance()
+		}
+		assert.Equal(t, state.FINAL_SCORE, r.state)
+		assert.Equal(t, 1000+3000+400, r.players[uids[0]].totalScore)
+		assert.Equal(t, 1000-300+1500, r.players[uids[1]].totalScore)
+		assert.Equal(t, 1000+1500, r.players[uids[2]].totalScore)
+		r.advance() // FINAL_SCORE -> WAITING
+		assert.Equal(t, state.WAITING, r.state)
+	})
+	assert.Equal(t, 6, m.count("onReady"))
+}
+
+func TestGameAboutYou(t *testing.T) {
+	r, _ := newTestGame(t)
+	uids := joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.settings.Mode = models.ABOUTYOU
+		r.launch()
+		r.advance() // STARTING -> TWO
+		current := r.turns[r.turn]
+		var others []string
+		for _, uid := range uids {
+			if uid != current {
+				others = append(others, uid)
+			}
+		}
+		assert.Equal(t, true, strings.HasPrefix(r.other.Question, current+" template"))
+		assert.Equal(t, true, r.players[current].current)
+
+		r.advance() // TWO -> INPUT_TRUTH
+		assert.Equal(t, state.INPUT_TRUTH, r.state)
+		res, _ := r.input(others[0], &InputMessage{Answer: "truth?"})
+		assert.Equal(t, "fail", res.Result)
+		res, _ = r.input(current, &InputMessage{Answer: "pizza"})
+		assert.Equal(t, "success", res.Result)
+		assert.Equal(t, state.INPUT_LIE_TEXT, r.state)
+
+		res, _ = r.input(current, &InputMessage{Answer: "a lie"})
+		assert.Equal(t, "fail", res.Result)
+		for _, uid := range others {
+			res, _ = r.input(uid, &InputMessage{Answer: "lie " + uid})
+			assert.Equal(t, "success", res.Result)
+		}
+		assert.Equal(t, state.THREE, r.state)
+		assert.Equal(t, len(others)+1, len(r.answers))
+		assert.Equal(t, "pizza", r.answers[r.players[current].question.ShuffledAnswerIdx])
+
+		r.advance() // THREE -> INPUT_TRUE_OPTION
+		res, _ = r.input(current, &InputMessage{AnswerId: r.players[current].question.ShuffledAnswerIdx})
+		assert.Equal(t, "fail", res.Result)
+		_, _ = r.input(others[0], &InputMessage{AnswerId: r.players[current].question.ShuffledAnswerIdx})
+		_, _ = r.input(others[1], &InputMessage{AnswerId: r.players[others[0]].shuffledAnswerIdx})
+		assert.Equal(t, state.SCORE, r.state)
+		assert.Equal(t, 1500, r.players[others[0]].totalScore)
+		assert.Equal(t, 0, r.players[others[1]].totalScore)
+		assert.Equal(t, 0, r.players[current].totalScore)
+
+		r.advance() // SCORE -> FINISH
+		assert.Equal(t, false, r.players[current].current)
+	})
+}
+
+func TestGameAboutYouNoTruth(t *testing.T) {
+	r, _ := newTestGame(t)
+	joinPlayers(t, r, 3)
+
+	r.exec(func() {
+		r.settings.Mode = models.ABOUTYOU
+		r.launch()
+		r.advance() // STARTING -> TWO
+		r.advance() // TWO -> INPUT_TRUTH
+		r.advance() // the named player let the time run out
+		assert.Equal(t, state.FINISH, r.state)
+	})
+}
@@ -0,0 +1,80 @@
+package factsv2
+
+import (
+	"context"
+	"github.com/topfreegames/pitaya"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+)
+
+// Ready toggles whether the player is ready to start the match from the lobby
+func (r *Game) Ready(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.ready(s.UID())
+	})
+	return res, nil
+}
+
+func (r *Game) ready(uid string) *Response {
+	p, ok := r.players[uid]
+	if !ok || p.spectator {
+		return &Response{Result: "fail"}
+	} else if r.state != state.WAITING {
+		logger.Log.Infof("wrong state to ready up: %s", r.state)
+		return &Response{Result: "fail"}
+	}
+
+	p.ready = !p.ready
+	err := r.messenger.Broadcast("onReady", &User{
+		UID:     uid,
+		IsReady: p.ready,
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	if p.ready {
+		r.checkLobby()
+	} else {
+		r.cancelCountdown()
+	}
+
+	return &Response{Code: 1, Result: "success"}
+}
+
+// checkLobby starts the countdown once enough players joined and all of them are ready
+func (r *Game) checkLobby() {
+	if r.counting || r.state != state.WAITING {
+		return
+	}
+	members := r.memberIds()
+	if len(GetPlayerIds(r.players, members)) < r.settings.MinPlayers || !ArePlayersReady(r.players, members) {
+		return
+	}
+
+	r.counting = true
+	r.schedule(r.settings.Countdown)
+	err := r.messenger.Broadcast("onCountdown", r.stamp(&Message{
+		State: r.state,
+		Ticks: r.settings.Countdown,
+	}))
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// cancelCountdown stops the lobby countdown if it is running
+func (r *Game) cancelCountdown() {
+	if !r.counting {
+		return
+	}
+	r.counting = false
+	r.stopTimer()
+	err := r.messenger.Broadcast("onCountdownCancelled", &Message{
+		State: r.state,
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
@@ -0,0 +1,52 @@
+package factsv2
+
+import (
+	"context"
+	"github.com/topfreegames/pitaya"
+)
+
+// FrontendType is the server type that holds the client sessions, the game
+// servers push through it when they run behind separate frontends
+var FrontendType = "game"
+
+type (
+	// Messenger delivers game messages to the members of a room
+	Messenger interface {
+		Broadcast(route string, v interface{}) error
+		Push(uid, route string, v interface{}) error
+		AddMember(uid string) error
+		RemoveMember(uid string) error
+		Members() ([]string, error)
+	}
+
+	// groupMessenger delivers messages through the pitaya group of a room
+	groupMessenger struct {
+		groupUuid string
+	}
+)
+
+// NewGroupMessenger returns a Messenger backed by the given pitaya group
+func NewGroupMessenger(groupUuid string) Messenger {
+	return &groupMessenger{groupUuid: groupUuid}
+}
+
+func (m *groupMessenger) Broadcast(route string, v interface{}) error {
+	return pitaya.GroupBroadcast(context.Background(), FrontendType, m.groupUuid, route, v)
+}
+
+func (m *groupMessenger) Push(uid, route string, v interface{}) error {
+	_, err := pitaya.SendPushToUsers(route, v, []string{uid}, FrontendType)
+	return err
+}
+
+func (m *groupMessenger) AddMember(uid string) error {
+	return pitaya.GroupAddMember(context.Background(), m.groupUuid, uid)
+}
+
+func (m *groupMessenger) RemoveMember(uid string) error {
+	return pitaya.GroupRemoveMember(context.Background(), m.groupUuid, uid)
+}
+
+func (m *groupMessenger) Members() ([]string, error) {
+	return pitaya.GroupMembers(context.Background(), m.groupUuid)
+}
@@ -1,15 +1,28 @@
 package factsv2
 
+import (
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"time"
+)
+
 type (
 	Message struct {
 		CurrentPlayerId string                      `json:"currentPlayerId,omitempty"`
 		Ticks           int                         `json:"ticks,omitempty"`
 		State           string                      `json:"state,omitempty"`
 		Answers         []string                    `json:"answers,omitempty"`
+		Question        *Question                   `json:"question,omitempty"`
 		Other           *Question                   `json:"otherQuestion,omitempty"`
 		Score           map[string]int              `json:"score,omitempty"`
 		Total           map[string]int              `json:"total,omitempty"`
 		Choices         map[string]*AnswerMatrixRow `json:"answerMatrix,omitempty"`
+		Paused          bool                        `json:"paused,omitempty"`
+		Series          map[string]*SeriesRow       `json:"series,omitempty"`
+		Deadline        int64                       `json:"deadline,omitempty"`
+		Now             int64                       `json:"now,omitempty"`
+		Reveal          *Reveal                     `json:"reveal,omitempty"`
+		Round           int                         `json:"round,omitempty"`
+		Multiplier      int                         `json:"multiplier,omitempty"`
 	}
 
 	Player struct {
@@ -26,12 +39,46 @@ type (
 		used              bool
 		current           bool
 		connected         bool
+		host              bool
+		joinedAt          time.Time
+		token             string
+		spectator         bool
+		rematch           bool
+		skip              bool
+		seriesScore       int
+		seriesWins        int
+		wager             int
+	}
+
+	// SeriesRow is a player's standing across the games played in the room
+	SeriesRow struct {
+		Score int `json:"score"`
+		Wins  int `json:"wins"`
+	}
+
+	// Tally is the state of a vote
+	Tally struct {
+		Votes  int `json:"votes"`
+		Needed int `json:"needed"`
 	}
 
 	AnswerMatrixRow struct {
 		Text      string   `json:"text,omitempty"`
+		AuthorIds []string `json:"authorIds,omitempty"`
 		PickedIds []string `json:"pickedIds,omitempty"`
 	}
+	// Round is a step of the round plan, a round without questions asks each player once
+	Round struct {
+		Questions  int `json:"questions,omitempty"`
+		Multiplier int `json:"multiplier,omitempty"`
+	}
+	// Reveal shows a single answer of the final round with who wrote and who picked it
+	Reveal struct {
+		Text      string   `json:"text"`
+		AuthorIds []string `json:"authorIds,omitempty"`
+		PickedIds []string `json:"pickedIds,omitempty"`
+		Truth     bool     `json:"truth,omitempty"`
+	}
 	UserReady struct {
 		UID   string `json:"id,omitempty"`
 		Ready bool   `json:"ready,omitempty"`
@@ -41,19 +88,71 @@ type (
 		CategoryId int    `json:"categoryId,omitempty"`
 		Answer     string `json:"answer,omitempty"`
 		AnswerId   int    `json:"answerId,omitempty"`
+		Wager      int    `json:"wager,omitempty"`
 	}
 	// NicknameMessage represents a message that user sent
 	NicknameMessage struct {
 		Nickname  string `json:"nickname"`
 		GroupUuid string `json:"uuid"`
 	}
+	// RejoinMessage represents a resume token sent by a reconnecting player
+	RejoinMessage struct {
+		Token     string `json:"token"`
+		GroupUuid string `json:"uuid"`
+	}
+
+	// Notice tells room members about something that happens to the room itself
+	Notice struct {
+		Reason string `json:"reason"`
+		Ticks  int    `json:"ticks,omitempty"`
+	}
+
+	// RoomSettings is sent by the room creator, durations are in seconds
+	RoomSettings struct {
+		Starting       int             `json:"starting,omitempty"`
+		Two            int             `json:"two,omitempty"`
+		Input          int             `json:"input,omitempty"`
+		Three          int             `json:"three,omitempty"`
+		Score          int             `json:"score,omitempty"`
+		Finish         int             `json:"finish,omitempty"`
+		Countdown      int             `json:"countdown,omitempty"`
+		Rounds         int             `json:"rounds,omitempty"`
+		SkipPercent    int             `json:"skipPercent,omitempty"`
+		Language       string          `json:"language,omitempty"`
+		Mode           models.ModeType `json:"mode"`
+		FamilyFriendly bool            `json:"familyFriendly"`
+		MinPlayers     int             `json:"minPlayers,omitempty"`
+		MaxPlayers     int             `json:"maxPlayers,omitempty"`
+		Final          bool            `json:"final"`
+		Plan           []Round         `json:"plan,omitempty"`
+		Fooled         string          `json:"fooled,omitempty"`
+	}
+
+	// TimeMessage carries the client clock of a clock sync request, in ms since epoch
+	TimeMessage struct {
+		ClientTime int64 `json:"clientTime"`
+	}
+	// TimeResponse lets the client estimate its clock offset from the server
+	TimeResponse struct {
+		ClientTime int64 `json:"clientTime"`
+		ServerTime int64 `json:"serverTime"`
+	}
+
+	// KickMessage represents a host request to remove a player from the room
+	KickMessage struct {
+		UID string `json:"id"`
+		Ban bool   `json:"ban,omitempty"`
+	}
 
 	// NewUser message will be received when new user join room
 	User struct {
-		UID      string `json:"id,omitempty"`
-		Name     string `json:"name,omitempty"`
-		Icon     string `json:"icon,omitempty"`
-		IsPlayer bool   `json:"isPlayer,omitempty"`
+		UID         string `json:"id,omitempty"`
+		Name        string `json:"name,omitempty"`
+		Icon        string `json:"icon,omitempty"`
+		IsPlayer    bool   `json:"isPlayer,omitempty"`
+		IsHost      bool   `json:"isHost,omitempty"`
+		IsSpectator bool   `json:"isSpectator,omitempty"`
+		IsReady     bool   `json:"isReady,omitempty"`
 	}
 
 	// AllMembers contains all members uid
@@ -65,10 +164,14 @@ type (
 	Response struct {
 		Code   int    `json:"code"`
 		Result string `json:"result"`
+		Uuid   string `json:"uuid,omitempty"`
+		Error  string `json:"error,omitempty"`
+		Token  string `json:"token,omitempty"`
 	}
 	Question struct {
-		Question          string `json:"question,omitempty"`
-		Answer            string `json:"answer,omitempty"`
-		ShuffledAnswerIdx int    `json:"shuffledIdx,omitempty"`
+		Question          string   `json:"question,omitempty"`
+		Answer            string   `json:"answer,omitempty"`
+		ShuffledAnswerIdx int      `json:"shuffledIdx,omitempty"`
+		Alternates        []string `json:"alternates,omitempty"`
 	}
 )
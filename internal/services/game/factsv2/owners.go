@@ -0,0 +1,170 @@
+package factsv2
+
+import (
+	"context"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/topfreegames/pitaya/logger"
+	"time"
+)
+
+type (
+	// Owners records which server runs each room so requests can be routed to it
+	Owners interface {
+		Claim(code string) (bool, error)
+		Release(code string) error
+		Owner(code string) (string, error)
+		// LeaseTTL is how long the rooms of a server that stopped stay claimed
+		LeaseTTL() time.Duration
+		// Lost is closed once the server can no longer hold on to its rooms
+		Lost() <-chan struct{}
+		// SetDraining tells the other servers that this one takes no new rooms
+		SetDraining() error
+		Draining() (map[string]bool, error)
+	}
+
+	// etcdOwners keeps room ownership in etcd under a lease of the owning server,
+	// the rooms of a server that dies are freed once its lease runs out
+	etcdOwners struct {
+		client   *clientv3.Client
+		prefix   string
+		serverID string
+		lease    clientv3.LeaseID
+		ttl      time.Duration
+		timeout  time.Duration
+		lost     chan struct{}
+	}
+)
+
+// NewEtcdOwners connects to etcd and grants the lease the rooms claimed by serverID are kept under
+func NewEtcdOwners(endpoints []string, prefix string, serverID string, ttl time.Duration) (Owners, error) {
+	timeout := 5 * time.Second
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	grant, err := client.Grant(ctx, int64(ttl/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	alive, err := client.KeepAlive(context.Background(), grant.ID)
+	if err != nil {
+		return nil, err
+	}
+	lost := make(chan struct{})
+	go func() {
+		for range alive {
+		}
+		logger.Log.Warn("room ownership lease is no longer kept alive")
+		close(lost)
+	}()
+	return &etcdOwners{
+		client:   client,
+		prefix:   prefix,
+		serverID: serverID,
+		lease:    grant.ID,
+		ttl:      ttl,
+		timeout:  timeout,
+		lost:     lost,
+	}, nil
+}
+
+// Claim takes the room for this server unless another server already owns it,
+// a room this server claimed in a previous run is moved over to the current lease
+func (o *etcdOwners) Claim(code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	key := o.prefix + code
+	res, err := o.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, o.serverID, clientv3.WithLease(o.lease))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if res.Succeeded {
+		return true, nil
+	}
+	kvs := res.Responses[0].GetResponseRange().Kvs
+	if len(kvs) == 0 || string(kvs[0].Value) != o.serverID {
+		return false, nil
+	} else if clientv3.LeaseID(kvs[0].Lease) == o.lease {
+		return true, nil
+	}
+	res, err = o.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", o.serverID)).
+		Then(clientv3.OpPut(key, o.serverID, clientv3.WithLease(o.lease))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// Release frees the room if this server owns it
+func (o *etcdOwners) Release(code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	key := o.prefix + code
+	_, err := o.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", o.serverID)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return err
+}
+
+// drainingKey is where the servers that take no new rooms are listed, under the prefix
+const drainingKey = "draining/"
+
+// Lost is closed when the lease stops being kept alive, the rooms claimed under it
+// are freed for other servers once it runs out
+func (o *etcdOwners) Lost() <-chan struct{} {
+	return o.lost
+}
+
+// LeaseTTL returns the time to live of the lease the rooms are claimed under
+func (o *etcdOwners) LeaseTTL() time.Duration {
+	return o.ttl
+}
+
+// SetDraining lists this server as draining until its lease runs out
+func (o *etcdOwners) SetDraining() error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	_, err := o.client.Put(ctx, o.prefix+drainingKey+o.serverID, o.serverID, clientv3.WithLease(o.lease))
+	return err
+}
+
+// Draining returns the ids of the servers that take no new rooms
+func (o *etcdOwners) Draining() (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	res, err := o.client.Get(ctx, o.prefix+drainingKey, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	draining := make(map[string]bool)
+	for _, kv := range res.Kvs {
+		draining[string(kv.Value)] = true
+	}
+	return draining, nil
+}
+
+// Owner returns the id of the server that runs the room, empty if nobody does
+func (o *etcdOwners) Owner(code string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	res, err := o.client.Get(ctx, o.prefix+code)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Kvs) == 0 {
+		return "", nil
+	}
+	return string(res.Kvs[0].Value), nil
+}
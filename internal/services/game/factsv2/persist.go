@@ -0,0 +1,102 @@
+package factsv2
+
+import (
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+	"time"
+)
+
+// setState changes the game phase and records it in the rooms table
+func (r *Game) setState(s string) {
+	r.state = s
+	r.saveRoom(map[string]interface{}{
+		"phase":      s,
+		"state_type": GetStateType(s),
+	})
+}
+
+// createRoom inserts a new rooms row that tracks the next match played in this room,
+// the row of the previous match is closed and a row no match started on is kept
+func (r *Game) createRoom() {
+	if r.db == nil {
+		return
+	} else if r.room != nil && r.room.StartedAt == nil {
+		return
+	}
+	r.saveClosed()
+	room := &models.Room{
+		Uuid:      r.groupUuid,
+		StateType: GetStateType(r.state),
+		ModeType:  r.settings.Mode,
+		Phase:     r.state,
+	}
+	if err := r.db.Create(room).Error; err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	r.room = room
+}
+
+func (r *Game) saveStarted(players int) {
+	now := time.Now()
+	r.saveRoom(map[string]interface{}{
+		"players":    players,
+		"started_at": &now,
+	})
+}
+
+// saveFinished stores the outcome of the match unless it was already recorded
+func (r *Game) saveFinished(outcome models.OutcomeType, winner string) {
+	if r.room == nil || r.room.FinishedAt != nil {
+		return
+	}
+	now := time.Now()
+	r.saveRoom(map[string]interface{}{
+		"phase":       r.state,
+		"state_type":  models.FINISH,
+		"finished_at": &now,
+		"outcome":     outcome,
+		"winner":      winner,
+	})
+}
+
+// saveClosed marks the room as torn down, closing every row still open for its code
+func (r *Game) saveClosed() {
+	if r.db == nil {
+		return
+	}
+	now := time.Now()
+	err := r.db.Model(&models.Room{}).
+		Where("uuid = ? AND closed_at IS NULL", r.groupUuid).
+		Updates(map[string]interface{}{"closed_at": &now}).Error
+	if err != nil {
+		logger.Log.Error(err)
+	} else if r.room != nil {
+		r.room.ClosedAt = &now
+	}
+}
+
+func (r *Game) saveRoom(fields map[string]interface{}) {
+	if r.db == nil || r.room == nil {
+		return
+	}
+	if err := r.db.Model(r.room).Updates(fields).Error; err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// GetStateType maps a game phase to the coarse state stored in the rooms table
+func GetStateType(s string) models.StateType {
+	switch s {
+	case state.STARTING, state.ONE, state.ROUND:
+		return models.ONE
+	case state.TWO, state.INPUT_TRUTH, state.INPUT_LIE_TEXT, state.FINAL, state.INPUT_FINAL_LIE_TEXT:
+		return models.TWO
+	case state.THREE, state.INPUT_TRUE_OPTION, state.SCORE, state.FINISH,
+		state.FINAL_THREE, state.INPUT_FINAL_OPTION, state.FINAL_REVEAL, state.FINAL_SCORE:
+		return models.THREE
+	default:
+		return models.WAIT
+	}
+}
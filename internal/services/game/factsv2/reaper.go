@@ -0,0 +1,103 @@
+package factsv2
+
+import (
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+	"time"
+)
+
+type (
+	// Expiry defines how long a room may live
+	Expiry struct {
+		// Idle closes rooms nobody sent a command to for this long
+		Idle time.Duration
+		// Hard closes rooms this long after they were created, active or not
+		Hard time.Duration
+		// Warning is how long before closing the members are told about it
+		Warning time.Duration
+	}
+)
+
+// touch records activity in the room, it must run on the command loop
+func (r *Game) touch() {
+	r.activeAt = time.Now()
+	r.warned = false
+}
+
+// reap warns the members of a room that is about to expire and closes it once it does
+func (r *Game) reap(now time.Time, expiry Expiry) {
+	reason, closeAt := GetRoomExpiry(r.createdAt, r.activeAt, expiry)
+	if !now.Before(closeAt) {
+		r.shutdown(reason)
+		return
+	} else if r.warned || closeAt.Sub(now) > expiry.Warning {
+		return
+	}
+	r.warned = true
+	err := r.messenger.Broadcast("onRoomClosing", &Notice{
+		Reason: reason,
+		Ticks:  GetRemainingTicks(closeAt, now),
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// drain lets the running game finish and closes the room right away if there is none
+func (r *Game) drain(grace time.Duration) {
+	r.draining = true
+	if r.state == state.WAITING {
+		r.shutdown(game.DRAINING)
+		return
+	}
+	err := r.messenger.Broadcast("onServerNotice", &Notice{
+		Reason: game.DRAINING,
+		Ticks:  int(grace / time.Second),
+	})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// shutdown removes every member, stops the match and the command loop
+func (r *Game) shutdown(reason string) {
+	logger.Log.Infof("closing room %s: %s", r.groupUuid, reason)
+	r.stopTimer()
+	err := r.messenger.Broadcast("onRoomClosed", &Notice{Reason: reason})
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	members, err := r.messenger.Members()
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	for _, uid := range members {
+		err := r.messenger.RemoveMember(uid)
+		if err != nil {
+			logger.Log.Error(err)
+		}
+		if r.onLeave != nil {
+			r.onLeave(uid)
+		}
+	}
+	if r.state != state.WAITING {
+		r.saveFinished(models.ABANDONED, "")
+	}
+	r.saveClosed()
+	if r.onEmpty != nil {
+		r.onEmpty()
+	}
+	r.close()
+}
+
+// GetRoomExpiry returns why and when a room is closed if nothing happens in it anymore
+func GetRoomExpiry(createdAt time.Time, activeAt time.Time, expiry Expiry) (string, time.Time) {
+	idleAt := activeAt.Add(expiry.Idle)
+	hardAt := createdAt.Add(expiry.Hard)
+	if hardAt.Before(idleAt) {
+		return game.EXPIRED, hardAt
+	}
+	return game.IDLE, idleAt
+}
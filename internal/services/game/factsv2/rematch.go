@@ -0,0 +1,65 @@
+package factsv2
+
+import (
+	"context"
+	"github.com/topfreegames/pitaya"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+)
+
+// Rematch toggles the player's vote to play again with the same lobby
+func (r *Game) Rematch(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.rematch(s.UID())
+	})
+	return res, nil
+}
+
+func (r *Game) rematch(uid string) *Response {
+	p, ok := r.players[uid]
+	if !ok || p.spectator {
+		return &Response{Result: "fail"}
+	} else if r.state != state.WAITING || !r.played {
+		logger.Log.Infof("no game to rematch in room %s", r.groupUuid)
+		return &Response{Result: "fail"}
+	}
+
+	p.rematch = !p.rematch
+	err := r.messenger.Broadcast("onRematchVote", r.rematchTally())
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	r.checkRematch()
+
+	return &Response{Code: 1, Result: "success"}
+}
+
+// rematchTally counts the votes of the connected players
+func (r *Game) rematchTally() *Tally {
+	playerIds := GetPlayerIds(r.players, r.memberIds())
+	votes := 0
+	for _, uid := range playerIds {
+		if r.players[uid].rematch {
+			votes++
+		}
+	}
+	return &Tally{
+		Votes:  votes,
+		Needed: GetMajority(len(playerIds)),
+	}
+}
+
+// checkRematch starts the next game once the majority voted for it
+func (r *Game) checkRematch() {
+	if r.state != state.WAITING || !r.played {
+		return
+	}
+	tally := r.rematchTally()
+	if tally.Votes < tally.Needed || len(GetPlayerIds(r.players, r.memberIds())) < r.settings.MinPlayers {
+		return
+	}
+	logger.Log.Infof("rematch in room %s", r.groupUuid)
+	r.launch()
+}
@@ -0,0 +1,614 @@
+package factsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"github.com/topfreegames/pitaya"
+	"github.com/topfreegames/pitaya/component"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/topfreegames/pitaya/session"
+	"github.com/topfreegames/pitaya/timer"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	errors2 "github.com/zdarovich/fibbage-game-server/internal/errors"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game"
+	"sync"
+	"time"
+)
+
+type (
+	// Rooms represents a component that keeps a registry of running games
+	// and dispatches game handlers to the room the session has joined
+	Rooms struct {
+		component.Base
+		timer    *timer.Timer
+		reaper   *timer.Timer
+		db       *gorm.DB
+		expiry   Expiry
+		owners   Owners
+		mu       sync.RWMutex
+		games    map[string]*Game
+		pending  map[string]bool
+		draining bool
+	}
+)
+
+const (
+	// reapInterval is how often rooms are checked for expiry
+	reapInterval = 10 * time.Second
+	// reclaimAttempts is how many times a room left by a previous run is claimed before it is closed
+	reclaimAttempts = 3
+)
+
+// NewRooms returns a Handler Base implementation, owners is nil when
+// a single server runs every room
+func NewRooms(db *gorm.DB, expiry Expiry, owners Owners) *Rooms {
+	return &Rooms{
+		db:      db,
+		expiry:  expiry,
+		owners:  owners,
+		games:   make(map[string]*Game),
+		pending: make(map[string]bool),
+	}
+}
+
+// AfterInit component lifetime callback
+func (r *Rooms) AfterInit() {
+	r.timer = pitaya.NewTimer(time.Minute, func() {
+		r.mu.RLock()
+		count := len(r.games)
+		r.mu.RUnlock()
+		logger.Log.Debugf("RoomCount: Time=> %s, Count=> %d", time.Now().String(), count)
+	})
+	r.reaper = pitaya.NewTimer(reapInterval, r.reap)
+	r.restore()
+	if r.owners != nil {
+		go func() {
+			<-r.owners.Lost()
+			r.abandon()
+		}()
+	}
+}
+
+// restore brings back the matches that were running when the server went down,
+// rooms that were left open without a match to resume are marked closed and
+// rooms still claimed under the lease of the previous run are restored once it runs out
+func (r *Rooms) restore() {
+	if r.db == nil {
+		return
+	}
+	var rooms []models.Room
+	err := r.db.Where("finished_at IS NULL AND closed_at IS NULL").Find(&rooms).Error
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	for i := range rooms {
+		room := &rooms[i]
+		owned, err := r.claim(room.Uuid)
+		if err != nil {
+			logger.Log.Error(err)
+		}
+		if !owned {
+			go r.reclaim(room)
+			continue
+		}
+		r.restoreRoom(room)
+	}
+}
+
+// reclaim waits for the lease of the previous run to run out and restores the room then,
+// the room is running on another server if its lease is still kept alive after that
+func (r *Rooms) reclaim(room *models.Room) {
+	time.Sleep(r.owners.LeaseTTL() + time.Second)
+	for i := 0; i < reclaimAttempts; i++ {
+		owned, err := r.claim(room.Uuid)
+		if err != nil {
+			logger.Log.Error(err)
+			time.Sleep(time.Second)
+			continue
+		} else if owned {
+			r.restoreRoom(room)
+		}
+		return
+	}
+	logger.Log.Warnf("room %s could not be claimed, closing it", room.Uuid)
+	r.close(room)
+}
+
+// restoreRoom resumes the match of a room this server has claimed
+func (r *Rooms) restoreRoom(room *models.Room) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := GetRestorableSnapshot(room)
+	if snapshot == nil {
+		r.close(room)
+		r.release(room.Uuid)
+		return
+	} else if r.games[room.Uuid] != nil {
+		r.close(room)
+		return
+	}
+	var err error
+	g := New(room.Uuid, r.db, snapshot.Settings)
+	g.exec(func() {
+		g.room = room
+		err = g.restore(snapshot)
+	})
+	if err == nil {
+		err = pitaya.GroupCreate(context.Background(), room.Uuid)
+	}
+	if err != nil {
+		logger.Log.Error(err)
+		g.exec(g.close)
+		r.close(room)
+		r.release(room.Uuid)
+		return
+	}
+	r.add(room.Uuid, g)
+	logger.Log.Infof("room restored: %s in %s", room.Uuid, snapshot.State)
+}
+
+// close marks a room row left open by a previous run as closed
+func (r *Rooms) close(room *models.Room) {
+	now := time.Now()
+	err := r.db.Model(room).Updates(map[string]interface{}{
+		"closed_at": &now,
+	}).Error
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// reap closes the rooms that have been idle or alive for too long
+func (r *Rooms) reap() {
+	now := time.Now()
+	r.mu.RLock()
+	games := r.list()
+	r.mu.RUnlock()
+	for _, g := range games {
+		g := g
+		g.send(func() {
+			g.reap(now, r.expiry)
+		})
+	}
+}
+
+// Create room with the settings sent and return its join code, an empty message creates a room with the defaults
+func (r *Rooms) Create(ctx context.Context, msg []byte) (*Response, error) {
+	settings := DefaultRoomSettings()
+	if len(msg) > 0 {
+		err := json.Unmarshal(msg, &settings)
+		if err != nil {
+			logger.Log.Infof("malformed room settings: %s", err)
+			return &Response{Result: "fail", Error: errors2.INVALID_SETTINGS}, nil
+		}
+	}
+	err := ValidateRoomSettings(&settings)
+	if err != nil {
+		logger.Log.Infof("invalid room settings: %s", err)
+		return &Response{Result: "fail", Error: errors2.INVALID_SETTINGS}, nil
+	} else if r.isDraining() {
+		return &Response{Result: "fail", Error: errors2.SERVER_DRAINING}, nil
+	}
+	err = r.checkContent(settings)
+	if errors.Is(err, ErrNoContent) {
+		logger.Log.Errorf("unsupported room settings: %s", err)
+		return &Response{Result: "fail", Error: errors2.INVALID_SETTINGS}, nil
+	} else if err != nil {
+		return nil, pitaya.Error(err, "RH-001", map[string]string{"failed": "create"})
+	}
+	code, err := r.create(ctx, settings)
+	if err != nil {
+		return nil, pitaya.Error(err, "RH-001", map[string]string{"failed": "create"})
+	}
+	return &Response{Code: 1, Result: "success", Uuid: code}, nil
+}
+
+// checkContent makes sure the questions in the database can play a match with the settings
+func (r *Rooms) checkContent(settings RoomSettings) error {
+	if r.db == nil {
+		return nil
+	}
+	return CheckContent(NewQuestionStore(r.db), NewTemplateStore(r.db), settings)
+}
+
+// Time answers a clock sync request
+func (r *Rooms) Time(ctx context.Context, msg *TimeMessage) (*TimeResponse, error) {
+	res := &TimeResponse{ServerTime: GetMillis(time.Now())}
+	if msg != nil {
+		res.ClientTime = msg.ClientTime
+	}
+	return res, nil
+}
+
+// Join room by its code
+func (r *Rooms) Join(ctx context.Context, msg *NicknameMessage) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil {
+		return &Response{Result: "fail"}, nil
+	} else if r.joined(s) {
+		logger.Log.Infof("session %s already joined room %s", s.UID(), s.String(game.ROOM))
+		return &Response{Result: "fail"}, nil
+	} else if r.isDraining() {
+		return &Response{Result: "fail", Error: errors2.SERVER_DRAINING}, nil
+	}
+	code := NormalizeRoomCode(msg.GroupUuid)
+	g := r.get(code)
+	if g == nil {
+		logger.Log.Infof("room not found: %s", code)
+		return &Response{Result: "fail"}, nil
+	}
+	res, err := g.Join(ctx, msg)
+	if err != nil || res.Result != "success" {
+		return res, err
+	}
+	err = r.setRoom(ctx, s, code)
+	if err != nil {
+		return nil, err
+	}
+	res.Uuid = code
+	return res, nil
+}
+
+// Spectate room by its code
+func (r *Rooms) Spectate(ctx context.Context, msg *NicknameMessage) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil || r.joined(s) {
+		return &Response{Result: "fail"}, nil
+	} else if r.isDraining() {
+		return &Response{Result: "fail", Error: errors2.SERVER_DRAINING}, nil
+	}
+	code := NormalizeRoomCode(msg.GroupUuid)
+	g := r.get(code)
+	if g == nil {
+		logger.Log.Infof("room not found: %s", code)
+		return &Response{Result: "fail"}, nil
+	}
+	res, err := g.Spectate(ctx, msg)
+	if err != nil || res.Result != "success" {
+		return res, err
+	}
+	err = r.setRoom(ctx, s, code)
+	if err != nil {
+		return nil, err
+	}
+	res.Uuid = code
+	return res, nil
+}
+
+// Rejoin room with the resume token handed out by Join
+func (r *Rooms) Rejoin(ctx context.Context, msg *RejoinMessage) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	if msg == nil || r.joined(s) {
+		return &Response{Result: "fail"}, nil
+	}
+	code := NormalizeRoomCode(msg.GroupUuid)
+	g := r.get(code)
+	if g == nil {
+		logger.Log.Infof("room not found: %s", code)
+		return &Response{Result: "fail"}, nil
+	}
+	res, err := g.Rejoin(ctx, msg)
+	if err != nil || res.Result != "success" {
+		return res, err
+	}
+	err = r.setRoom(ctx, s, code)
+	if err != nil {
+		return nil, err
+	}
+	res.Uuid = code
+	return res, nil
+}
+
+func (r *Rooms) Start(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Start(ctx, msg)
+}
+
+func (r *Rooms) Restart(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Restart(ctx, msg)
+}
+
+func (r *Rooms) Stop(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Stop(ctx, msg)
+}
+
+func (r *Rooms) Ready(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Ready(ctx, msg)
+}
+
+func (r *Rooms) Rematch(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Rematch(ctx, msg)
+}
+
+func (r *Rooms) Skip(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Skip(ctx, msg)
+}
+
+func (r *Rooms) Pause(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Pause(ctx, msg)
+}
+
+func (r *Rooms) Resume(ctx context.Context, msg []byte) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Resume(ctx, msg)
+}
+
+func (r *Rooms) Kick(ctx context.Context, msg *KickMessage) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Kick(ctx, msg)
+}
+
+func (r *Rooms) Input(ctx context.Context, msg *InputMessage) (*Response, error) {
+	g := r.sessionGame(ctx)
+	if g == nil {
+		return &Response{Result: "fail"}, nil
+	}
+	return g.Input(ctx, msg)
+}
+
+func (r *Rooms) create(ctx context.Context, settings RoomSettings) (string, error) {
+	code, err := r.reserve()
+	if err != nil {
+		return "", err
+	}
+	err = pitaya.GroupCreate(ctx, code)
+	if err != nil {
+		r.unreserve(code)
+		r.release(code)
+		return "", err
+	}
+	g := New(code, r.db, settings)
+	g.exec(g.createRoom)
+	r.mu.Lock()
+	delete(r.pending, code)
+	r.add(code, g)
+	r.mu.Unlock()
+	logger.Log.Infof("room created: %s", code)
+	return code, nil
+}
+
+// reserve picks a free room code and claims it for this server, the code stays
+// pending while the claim is in flight so that no other create can pick it
+func (r *Rooms) reserve() (string, error) {
+	for i := 0; i < 10; i++ {
+		code, err := GenerateRoomCode()
+		if err != nil {
+			return "", err
+		}
+		r.mu.Lock()
+		_, taken := r.games[code]
+		taken = taken || r.pending[code]
+		if !taken {
+			r.pending[code] = true
+		}
+		r.mu.Unlock()
+		if taken {
+			continue
+		}
+		owned, err := r.claim(code)
+		if err != nil {
+			r.unreserve(code)
+			r.release(code) // the claim may have gone through before it failed
+			return "", err
+		} else if owned {
+			return code, nil
+		}
+		r.unreserve(code)
+	}
+	return "", errors.New("no free room code")
+}
+
+func (r *Rooms) unreserve(code string) {
+	r.mu.Lock()
+	delete(r.pending, code)
+	r.mu.Unlock()
+}
+
+// add registers the game under its code, the caller must hold the lock
+func (r *Rooms) add(code string, g *Game) {
+	g.exec(func() {
+		g.onEmpty = func() {
+			r.remove(code)
+		}
+		g.onLeave = func(uid string) {
+			r.leave(uid)
+		}
+	})
+	r.games[code] = g
+}
+
+func (r *Rooms) remove(code string) {
+	r.mu.Lock()
+	delete(r.games, code)
+	r.mu.Unlock()
+	err := pitaya.GroupDelete(context.Background(), code)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	r.release(code)
+	logger.Log.Infof("room removed: %s", code)
+}
+
+// claim takes ownership of the room code for this server
+func (r *Rooms) claim(code string) (bool, error) {
+	if r.owners == nil {
+		return true, nil
+	}
+	return r.owners.Claim(code)
+}
+
+func (r *Rooms) release(code string) {
+	if r.owners == nil {
+		return
+	}
+	err := r.owners.Release(code)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// setRoom remembers the room on the session, a backend hands it on to the
+// frontend so that the following requests get routed to the room owner
+func (r *Rooms) setRoom(ctx context.Context, s *session.Session, code string) error {
+	err := s.Set(game.ROOM, code)
+	if err != nil || s.IsFrontend {
+		return err
+	}
+	return s.PushToFront(ctx)
+}
+
+// joined reports whether the session is still in the room it joined last,
+// frontends keep the room of players a backend has already let go
+func (r *Rooms) joined(s *session.Session) bool {
+	if !s.HasKey(game.ROOM) {
+		return false
+	}
+	g := r.get(s.String(game.ROOM))
+	return g != nil && g.Has(s.UID())
+}
+
+// leave forgets the room the player's session has joined
+func (r *Rooms) leave(uid string) {
+	s := session.GetSessionByUID(uid)
+	if s == nil {
+		return
+	}
+	err := s.Remove(game.ROOM)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// Drain stops accepting new rooms and players, lets the running games finish
+// for up to grace and closes whatever is left after that
+func (r *Rooms) Drain(grace time.Duration) {
+	r.mu.Lock()
+	if r.draining {
+		r.mu.Unlock()
+		return
+	}
+	r.draining = true
+	games := r.list()
+	r.mu.Unlock()
+
+	if r.owners != nil {
+		err := r.owners.SetDraining() // the frontends stop sending new rooms here
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}
+	logger.Log.Infof("draining %d rooms", len(games))
+	for _, g := range games {
+		g := g
+		g.exec(func() {
+			g.drain(grace)
+		})
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timeout := time.After(grace)
+	for {
+		r.mu.RLock()
+		games = r.list()
+		r.mu.RUnlock()
+		if len(games) == 0 {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			logger.Log.Infof("closing %d rooms after the drain grace period", len(games))
+			for _, g := range games {
+				g := g
+				g.exec(func() {
+					g.shutdown(game.DRAINING)
+				})
+			}
+			return
+		}
+	}
+}
+
+// abandon closes every room once this server lost their ownership,
+// another server could otherwise take their codes over while players are still in them
+func (r *Rooms) abandon() {
+	r.mu.Lock()
+	r.draining = true // nothing new can be claimed either
+	games := r.list()
+	r.mu.Unlock()
+
+	logger.Log.Warnf("closing %d rooms after losing their ownership", len(games))
+	for _, g := range games {
+		g := g
+		g.exec(func() {
+			g.shutdown(game.ABANDONED)
+		})
+	}
+}
+
+func (r *Rooms) isDraining() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.draining
+}
+
+// list returns the running games, the caller must hold the lock
+func (r *Rooms) list() []*Game {
+	games := make([]*Game, 0, len(r.games))
+	for _, g := range r.games {
+		games = append(games, g)
+	}
+	return games
+}
+
+func (r *Rooms) get(code string) *Game {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.games[code]
+}
+
+func (r *Rooms) sessionGame(ctx context.Context) *Game {
+	s := pitaya.GetSessionFromCtx(ctx)
+	code := s.String(game.ROOM)
+	if code == "" {
+		logger.Log.Infof("session %s has not joined a room", s.UID())
+		return nil
+	}
+	return r.get(code)
+}
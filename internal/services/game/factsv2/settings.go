@@ -0,0 +1,110 @@
+package factsv2
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game"
+)
+
+// ErrNoContent is returned for settings the question sets of the language cannot play
+var ErrNoContent = errors.New("not enough content")
+
+// DefaultRoomSettings returns the settings used for anything the room creator left out
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		Starting:    5,
+		Two:         5,
+		Input:       30,
+		Three:       5,
+		Score:       10,
+		Finish:      5,
+		Countdown:   game.Countdown,
+		Rounds:      1,
+		SkipPercent: 51,
+		Language:    "ru",
+		Mode:        models.FACT,
+		MinPlayers:  game.MinPlayers,
+		MaxPlayers:  game.MaxPlayers,
+		Final:       true,
+		Fooled:      game.SHARE,
+	}
+}
+
+// ValidateRoomSettings checks the settings a room creator sent
+func ValidateRoomSettings(s *RoomSettings) error {
+	for _, d := range []int{s.Starting, s.Two, s.Input, s.Three, s.Score, s.Finish, s.Countdown} {
+		if d < 1 || d > game.MaxPhaseTicks {
+			return errors.New("phase duration out of range")
+		}
+	}
+	if s.Rounds < 1 || s.Rounds > game.MaxRounds {
+		return errors.New("rounds out of range")
+	}
+	if len(s.Plan) > game.MaxRounds {
+		return errors.New("too many rounds in plan")
+	}
+	for _, round := range s.Plan {
+		if round.Questions < 0 || round.Questions > game.MaxQuestions || round.Multiplier < 0 || round.Multiplier > game.MaxMultiplier {
+			return errors.New("round plan out of range")
+		}
+	}
+	if s.Fooled != game.SHARE && s.Fooled != game.SPLIT {
+		return errors.New("unknown fooled points rule")
+	}
+	if s.SkipPercent < 1 || s.SkipPercent > 100 {
+		return errors.New("skip percent out of range")
+	}
+	if !isLanguage(s.Language) {
+		return errors.New("unknown language")
+	}
+	if s.Mode != models.FACT && s.Mode != models.ABOUTYOU {
+		return errors.New("unsupported mode")
+	}
+	if s.MinPlayers < game.MinPlayers || s.MaxPlayers > game.MaxPlayersLimit || s.MinPlayers > s.MaxPlayers {
+		return errors.New("player limits out of range")
+	}
+	return nil
+}
+
+// CheckContent makes sure the question sets of the room's language can play a match with the settings,
+// a full room must not run out of questions
+func CheckContent(store QuestionStore, templates TemplateStore, s RoomSettings) error {
+	needed := 0
+	for _, round := range GetRoundPlan(s, s.MaxPlayers) {
+		needed += round.Questions
+	}
+	if s.Mode == models.FACT {
+		questions, err := store.Questions(s.Language, s.FamilyFriendly)
+		if err != nil {
+			return err
+		} else if len(questions) < needed {
+			return fmt.Errorf("%w: %d of %d questions for %s", ErrNoContent, len(questions), needed, s.Language)
+		}
+	} else {
+		about, err := templates.Templates(s.Language, s.FamilyFriendly)
+		if err != nil {
+			return err
+		} else if len(about) < needed {
+			return fmt.Errorf("%w: %d of %d question templates for %s", ErrNoContent, len(about), needed, s.Language)
+		}
+	}
+	if s.Final {
+		finals, err := store.Finals(s.Language, s.FamilyFriendly)
+		if err != nil {
+			return err
+		} else if len(finals) == 0 {
+			return fmt.Errorf("%w: no final questions for %s", ErrNoContent, s.Language)
+		}
+	}
+	return nil
+}
+
+func isLanguage(langCode string) bool {
+	for _, l := range game.Languages {
+		if l == langCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package factsv2
+
+import (
+	"context"
+	"github.com/topfreegames/pitaya"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+)
+
+// Skip votes to cut the current reveal phase short
+func (r *Game) Skip(ctx context.Context, msg []byte) (*Response, error) {
+	s := pitaya.GetSessionFromCtx(ctx)
+	res := &Response{Result: "fail"}
+	r.act(func() {
+		res = r.skip(s.UID())
+	})
+	return res, nil
+}
+
+func (r *Game) skip(uid string) *Response {
+	p, ok := r.players[uid]
+	if !ok || p.spectator || p.skip {
+		return &Response{Result: "fail"}
+	} else if r.paused || !IsRevealState(r.state) {
+		logger.Log.Infof("wrong state to skip: %s", r.state)
+		return &Response{Result: "fail"}
+	}
+
+	p.skip = true
+	tally := r.skipTally()
+	err := r.messenger.Broadcast("onSkipVote", tally)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	if tally.Votes >= tally.Needed {
+		r.advance()
+	}
+
+	return &Response{Code: 1, Result: "success"}
+}
+
+// skipTally counts the skip votes of the connected players
+func (r *Game) skipTally() *Tally {
+	playerIds := GetPlayerIds(r.players, r.memberIds())
+	votes := 0
+	for _, uid := range playerIds {
+		if r.players[uid].skip {
+			votes++
+		}
+	}
+	return &Tally{
+		Votes:  votes,
+		Needed: GetVotesNeeded(len(playerIds), r.settings.SkipPercent),
+	}
+}
+
+// IsRevealState reports whether the phase only shows results and can be skipped
+func IsRevealState(s string) bool {
+	switch s {
+	case state.ROUND, state.TWO, state.THREE, state.SCORE, state.FINISH,
+		state.FINAL, state.FINAL_THREE, state.FINAL_REVEAL, state.FINAL_SCORE:
+		return true
+	default:
+		return false
+	}
+}
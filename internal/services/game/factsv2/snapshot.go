@@ -0,0 +1,228 @@
+package factsv2
+
+import (
+	"encoding/json"
+	"github.com/topfreegames/pitaya/logger"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+	"time"
+)
+
+type (
+	// Snapshot is the state of a room checkpointed at each phase boundary
+	Snapshot struct {
+		State     string                     `json:"state"`
+		Deadline  int64                      `json:"deadline"`
+		Remaining int64                      `json:"remaining"`
+		Settings  RoomSettings               `json:"settings"`
+		Players   map[string]*PlayerSnapshot `json:"players"`
+		Banned    []string                   `json:"banned,omitempty"`
+		Lineup    []string                   `json:"lineup"`
+		Turns     []string                   `json:"turns"`
+		Turn      int                        `json:"turn"`
+		Plan      []Round                    `json:"plan,omitempty"`
+		Rounds    []int                      `json:"rounds,omitempty"`
+		Round     int                        `json:"round"`
+		Pool      []uint                     `json:"pool"`
+		Other     *Question                  `json:"other,omitempty"`
+		Answers   []string                   `json:"answers,omitempty"`
+		Played    bool                       `json:"played,omitempty"`
+		Final     *Question                  `json:"final,omitempty"`
+		Reveals   []*Reveal                  `json:"reveals,omitempty"`
+		Reveal    int                        `json:"reveal,omitempty"`
+		Dropped   bool                       `json:"dropped,omitempty"`
+	}
+
+	// PlayerSnapshot is the checkpointed state of a single player
+	PlayerSnapshot struct {
+		Name              string    `json:"name"`
+		Icon              string    `json:"icon"`
+		Token             string    `json:"token"`
+		Host              bool      `json:"host,omitempty"`
+		Spectator         bool      `json:"spectator,omitempty"`
+		JoinedAt          time.Time `json:"joinedAt"`
+		Question          *Question `json:"question,omitempty"`
+		TotalScore        int       `json:"totalScore"`
+		AnswerLie         string    `json:"answerLie,omitempty"`
+		ShuffledAnswerIdx int       `json:"shuffledAnswerIdx"`
+		AnswerTruthId     int       `json:"answerTruthId"`
+		Ready             bool      `json:"ready,omitempty"`
+		SeriesScore       int       `json:"seriesScore,omitempty"`
+		SeriesWins        int       `json:"seriesWins,omitempty"`
+		Wager             int       `json:"wager,omitempty"`
+		Current           bool      `json:"current,omitempty"`
+	}
+)
+
+// checkpoint stores the room state so the match survives a server restart
+func (r *Game) checkpoint() {
+	if r.db == nil || r.room == nil {
+		return
+	}
+	data, err := json.Marshal(r.snapshot())
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	r.saveRoom(map[string]interface{}{
+		"snapshot": string(data),
+	})
+}
+
+func (r *Game) snapshot() *Snapshot {
+	now := time.Now()
+	snapshot := &Snapshot{
+		State:    r.state,
+		Settings: r.settings,
+		Players:  make(map[string]*PlayerSnapshot),
+		Lineup:   r.lineup,
+		Turns:    r.turns,
+		Turn:     r.turn,
+		Plan:     r.plan,
+		Rounds:   r.rounds,
+		Round:    r.round,
+		Other:    r.other,
+		Answers:  r.answers,
+		Played:   r.played,
+		Final:    r.final,
+		Reveals:  r.reveals,
+		Reveal:   r.reveal,
+		Dropped:  r.dropped,
+	}
+	remaining := r.remaining
+	if !r.paused && !r.deadline.IsZero() {
+		snapshot.Deadline = GetMillis(r.deadline)
+		remaining = r.deadline.Sub(now)
+	}
+	snapshot.Remaining = int64(remaining / time.Millisecond)
+	for uid, p := range r.players {
+		snapshot.Players[uid] = &PlayerSnapshot{
+			Name:              p.name,
+			Icon:              p.iconName,
+			Token:             p.token,
+			Host:              p.host,
+			Spectator:         p.spectator,
+			JoinedAt:          p.joinedAt,
+			Question:          p.question,
+			TotalScore:        p.totalScore,
+			AnswerLie:         p.answerLie,
+			ShuffledAnswerIdx: p.shuffledAnswerIdx,
+			AnswerTruthId:     p.answerTruthId,
+			Ready:             p.ready,
+			SeriesScore:       p.seriesScore,
+			SeriesWins:        p.seriesWins,
+			Wager:             p.wager,
+			Current:           p.current,
+		}
+	}
+	for key := range r.banned {
+		snapshot.Banned = append(snapshot.Banned, key)
+	}
+	for _, q := range r.pool {
+		snapshot.Pool = append(snapshot.Pool, q.ID)
+	}
+	return snapshot
+}
+
+// restore loads a checkpointed match, it stays paused until the players rejoin
+func (r *Game) restore(snapshot *Snapshot) error {
+	questions, err := r.questions()
+	if err != nil {
+		return err
+	}
+	pool := make(map[uint]bool)
+	for _, id := range snapshot.Pool {
+		pool[id] = true
+	}
+	for _, q := range questions {
+		if pool[q.ID] {
+			r.pool = append(r.pool, q)
+		}
+	}
+
+	r.state = snapshot.State
+	r.lineup = snapshot.Lineup
+	r.turns = snapshot.Turns
+	r.turn = snapshot.Turn
+	r.plan = snapshot.Plan
+	r.rounds = snapshot.Rounds
+	r.round = snapshot.Round
+	r.other = snapshot.Other
+	r.answers = snapshot.Answers
+	r.played = snapshot.Played
+	r.final = snapshot.Final
+	r.reveals = snapshot.Reveals
+	r.reveal = snapshot.Reveal
+	r.dropped = snapshot.Dropped
+	for _, key := range snapshot.Banned {
+		r.banned[key] = true
+	}
+	for uid, p := range snapshot.Players {
+		r.players[uid] = &Player{
+			name:              p.Name,
+			iconName:          p.Icon,
+			token:             p.Token,
+			host:              p.Host,
+			spectator:         p.Spectator,
+			joinedAt:          p.JoinedAt,
+			question:          p.Question,
+			totalScore:        p.TotalScore,
+			answerLie:         p.AnswerLie,
+			shuffledAnswerIdx: p.ShuffledAnswerIdx,
+			answerTruthId:     p.AnswerTruthId,
+			ready:             p.Ready,
+			seriesScore:       p.SeriesScore,
+			seriesWins:        p.SeriesWins,
+			wager:             p.Wager,
+			current:           p.Current,
+		}
+		r.tokens[p.Token] = uid
+	}
+	r.paused = true
+	r.remaining = time.Duration(snapshot.Remaining) * time.Millisecond
+	r.restored = true
+	return nil
+}
+
+// checkRestored resumes a restored match once its players are back,
+// the ones that take longer than the rejoin window miss their turns
+func (r *Game) checkRestored() {
+	if !r.restored {
+		return
+	}
+	for _, uid := range r.lineup {
+		if p, ok := r.players[uid]; ok && !p.connected {
+			if r.deadline.IsZero() {
+				r.arm(time.Duration(r.settings.Input)*time.Second, r.resumeRestored)
+			}
+			return
+		}
+	}
+	r.resumeRestored()
+}
+
+func (r *Game) resumeRestored() {
+	if !r.restored {
+		return
+	}
+	r.restored = false
+	r.unpause()
+}
+
+// GetRestorableSnapshot returns the checkpoint of a match that was still running
+// when the room row was last written, nil if there is nothing to resume
+func GetRestorableSnapshot(room *models.Room) *Snapshot {
+	if room.Snapshot == "" {
+		return nil
+	}
+	snapshot := &Snapshot{}
+	err := json.Unmarshal([]byte(room.Snapshot), snapshot)
+	if err != nil {
+		logger.Log.Error(err)
+		return nil
+	}
+	if snapshot.State == "" || snapshot.State == state.WAITING {
+		return nil
+	}
+	return snapshot
+}
@@ -0,0 +1,72 @@
+package factsv2
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+)
+
+type (
+	// QuestionStore loads the questions a match is played with
+	QuestionStore interface {
+		Questions(langCode string, familyFriendly bool) ([]models.Question, error)
+		Finals(langCode string, familyFriendly bool) ([]models.Question, error)
+	}
+
+	// TemplateStore loads the question templates the About You mode is played with
+	TemplateStore interface {
+		Templates(langCode string, familyFriendly bool) ([]models.QuestionTemplate, error)
+	}
+
+	dbQuestionStore struct {
+		db *gorm.DB
+	}
+
+	dbTemplateStore struct {
+		db *gorm.DB
+	}
+)
+
+// NewQuestionStore returns a QuestionStore backed by the questions table
+func NewQuestionStore(db *gorm.DB) QuestionStore {
+	return &dbQuestionStore{db: db}
+}
+
+func (q *dbQuestionStore) Questions(langCode string, familyFriendly bool) ([]models.Question, error) {
+	return q.find(langCode, familyFriendly, false)
+}
+
+// Finals returns the questions of the final round
+func (q *dbQuestionStore) Finals(langCode string, familyFriendly bool) ([]models.Question, error) {
+	return q.find(langCode, familyFriendly, true)
+}
+
+// find loads the questions of a language, rows added before the final column existed are not finals
+func (q *dbQuestionStore) find(langCode string, familyFriendly bool, final bool) ([]models.Question, error) {
+	var questions []models.Question
+	query := q.db.Where("lang_code = ?", langCode)
+	if final {
+		query = query.Where("final = ?", true)
+	} else {
+		query = query.Where("final IS NOT TRUE")
+	}
+	if familyFriendly {
+		query = query.Where("explicit IS NOT TRUE") // rows added before the column existed are clean
+	}
+	err := query.Find(&questions).Error
+	return questions, err
+}
+
+// NewTemplateStore returns a TemplateStore backed by the question_templates table
+func NewTemplateStore(db *gorm.DB) TemplateStore {
+	return &dbTemplateStore{db: db}
+}
+
+func (q *dbTemplateStore) Templates(langCode string, familyFriendly bool) ([]models.QuestionTemplate, error) {
+	var templates []models.QuestionTemplate
+	query := q.db.Where("lang_code = ?", langCode)
+	if familyFriendly {
+		query = query.Where("explicit IS NOT TRUE") // rows added before the column existed are clean
+	}
+	err := query.Find(&templates).Error
+	return templates, err
+}
@@ -1,14 +1,44 @@
 package factsv2
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"fmt"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game"
+	"math"
+	"math/big"
+	"sort"
 	"strings"
+	"time"
 )
 
+func GenerateRoomCode() (string, error) {
+	alphabet := []rune(game.RoomCodeAlphabet)
+	code := make([]rune, game.RoomCodeLength)
+	for i := range code {
+		randIdx, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[randIdx.Int64()]
+	}
+	return string(code), nil
+}
+
+func NormalizeRoomCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
 func GetCurrentAnswers(players map[string]*Player, currentPlayerId string) []string {
 	var res []string
 	res = append(res, strings.ToLower(players[currentPlayerId].question.Answer))
-	for _, p := range players {
+	uids := make([]string, 0, len(players))
+	for uid := range players {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids) // answer indexes must not depend on map iteration order
+	for _, uid := range uids {
+		p := players[uid]
 		if p.answerLie == "" {
 			p.answerLie = fmt.Sprintf("%s's lie", p.name) // if player missed answer in round 2 return random
 		}
@@ -28,6 +58,65 @@ func GetCurrentPlayerId(players map[string]*Player) string {
 	return ""
 }
 
+// GetRemainingTicks returns the whole seconds left until the phase deadline
+func GetRemainingTicks(deadline time.Time, now time.Time) int {
+	if !deadline.After(now) {
+		return 0
+	}
+	return int(math.Ceil(deadline.Sub(now).Seconds()))
+}
+
+// GetMillis returns the time in milliseconds since epoch as sent to clients
+func GetMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func GetHostId(players map[string]*Player) string {
+	for uid, p := range players {
+		if p.host {
+			return uid
+		}
+	}
+	return ""
+}
+
+// GetNextHostId returns the connected member that joined the room first
+func GetNextHostId(players map[string]*Player, members []string) string {
+	nextHostId := ""
+	for _, uid := range members {
+		p, ok := players[uid]
+		if !ok || !p.connected || p.host || p.spectator {
+			continue
+		}
+		if nextHostId == "" || p.joinedAt.Before(players[nextHostId].joinedAt) {
+			nextHostId = uid
+		}
+	}
+	return nextHostId
+}
+
+// GetLeaderId returns the player with the highest score, nobody leads a tie or a match nobody scored in
+func GetLeaderId(players map[string]*Player) string {
+	leaderId := ""
+	best := 0
+	tied := false
+	for uid, p := range players {
+		if p.spectator || p.totalScore < best {
+			continue
+		} else if p.totalScore == best {
+			tied = true
+			continue
+		}
+		leaderId = uid
+		best = p.totalScore
+		tied = false
+	}
+	if tied {
+		return ""
+	}
+	return leaderId
+}
+
 func GetPlayerIdByLieAnswer(players map[string]*Player, answer string) string {
 	for uid, p := range players {
 		if strings.ToLower(p.answerLie) == strings.ToLower(answer) {
@@ -39,6 +128,9 @@ func GetPlayerIdByLieAnswer(players map[string]*Player, answer string) string {
 
 func GetPlayerIdByShuffledAnswerIdx(players map[string]*Player, shuffledAnswerIdx int) string {
 	for uid, p := range players {
+		if p.spectator {
+			continue
+		}
 		if p.shuffledAnswerIdx == shuffledAnswerIdx {
 			return uid
 		}
@@ -46,6 +138,32 @@ func GetPlayerIdByShuffledAnswerIdx(players map[string]*Player, shuffledAnswerId
 	return ""
 }
 
+// GetPlayerIdsByShuffledAnswerIdx returns every author of an answer, players that wrote the same lie share it
+func GetPlayerIdsByShuffledAnswerIdx(players map[string]*Player, shuffledAnswerIdx int) []string {
+	var res []string
+	if shuffledAnswerIdx < 0 {
+		return res
+	}
+	for uid, p := range players {
+		if p.spectator {
+			continue
+		}
+		if p.shuffledAnswerIdx == shuffledAnswerIdx {
+			res = append(res, uid)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// GetFooledPoints returns the points each author of a picked lie gets under the room's rule
+func GetFooledPoints(points int, authors int, rule string) int {
+	if rule == game.SPLIT && authors > 1 {
+		return points / authors
+	}
+	return points
+}
+
 func GetPlayersScore(players map[string]*Player, currentPlayerId string, currentAnswers []string) map[string]int {
 	scoreMap := make(map[string]int)
 	for uid, _ := range players {
@@ -71,41 +189,77 @@ func GetPlayersScore(players map[string]*Player, currentPlayerId string, current
 	return scoreMap
 }
 
-func GetPlayersScoreV2(players map[string]*Player, currentPlayerId string) map[string]int {
+// GetPlayersScoreV2 returns the points of a question scaled by the multiplier of its round,
+// the authors of a shared lie are paid according to the rule
+func GetPlayersScoreV2(players map[string]*Player, currentPlayerId string, multiplier int, rule string) map[string]int {
 	scoreMap := make(map[string]int)
-	for uid, _ := range players {
+	for uid, p := range players {
+		if p.spectator {
+			continue
+		}
 		scoreMap[uid] = 0
 	}
 	currentTruthAnswerId := players[currentPlayerId].question.ShuffledAnswerIdx
 	for uid, player := range players {
-		if !player.ready {
+		if player.spectator || player.current {
+			continue // spectators don't play, the named player knows the truth
+		} else if !player.ready {
 			continue // we don't count score for missed answer
 		}
 		if player.answerTruthId == currentTruthAnswerId {
-			scoreMap[uid] = scoreMap[uid] + 1000
+			scoreMap[uid] = scoreMap[uid] + 1000*multiplier
 		} else {
-			lyingPlayerId := GetPlayerIdByShuffledAnswerIdx(players, player.answerTruthId)
-			if lyingPlayerId != "" {
-				scoreMap[lyingPlayerId] = scoreMap[lyingPlayerId] + 500
+			authorIds := GetPlayerIdsByShuffledAnswerIdx(players, player.answerTruthId)
+			for _, lyingPlayerId := range authorIds {
+				scoreMap[lyingPlayerId] = scoreMap[lyingPlayerId] + GetFooledPoints(500*multiplier, len(authorIds), rule)
 			}
 		}
 	}
 	return scoreMap
 }
 
+// GetRoundPlan returns the rounds of a match for the given number of players,
+// without a plan every round asks each player once and is worth one more time the points
+func GetRoundPlan(settings RoomSettings, players int) []Round {
+	var plan []Round
+	if len(settings.Plan) == 0 {
+		for i := 0; i < settings.Rounds; i++ {
+			plan = append(plan, Round{Questions: players, Multiplier: i + 1})
+		}
+		return plan
+	}
+	for _, round := range settings.Plan {
+		if round.Questions == 0 {
+			round.Questions = players
+		}
+		if round.Multiplier == 0 {
+			round.Multiplier = 1
+		}
+		plan = append(plan, round)
+	}
+	return plan
+}
+
 func GetAnswersMatrix(players map[string]*Player, currentPlayerId string) map[string]*AnswerMatrixRow {
 	var result = make(map[string]*AnswerMatrixRow)
 
-	for uid, _ := range players {
+	for uid, p := range players {
+		if p.spectator {
+			continue
+		}
 		result[uid] = &AnswerMatrixRow{}
 	}
 	result["truth"] = &AnswerMatrixRow{Text: strings.ToLower(players[currentPlayerId].question.Answer)}
 	currentPlayerTruthAnswrIdx := players[currentPlayerId].question.ShuffledAnswerIdx
 	for lUid, lyingPlayer := range players {
+		if lyingPlayer.spectator {
+			continue
+		}
 		result[lUid].Text = strings.ToLower(lyingPlayer.answerLie)
+		result[lUid].AuthorIds = GetPlayerIdsByShuffledAnswerIdx(players, lyingPlayer.shuffledAnswerIdx)
 
 		for fUid, fooledPlayer := range players {
-			if !fooledPlayer.ready {
+			if fooledPlayer.spectator || fooledPlayer.current || !fooledPlayer.ready {
 				continue // we don't count score for missed answer
 			}
 			if fooledPlayer.answerTruthId == lyingPlayer.shuffledAnswerIdx {
@@ -114,7 +268,7 @@ func GetAnswersMatrix(players map[string]*Player, currentPlayerId string) map[st
 		}
 	}
 	for uid, p := range players {
-		if !p.ready {
+		if p.spectator || p.current || !p.ready {
 			continue // we don't count score for missed answer
 		}
 		if p.answerTruthId == currentPlayerTruthAnswrIdx {
@@ -126,9 +280,136 @@ func GetAnswersMatrix(players map[string]*Player, currentPlayerId string) map[st
 	return result
 }
 
+// GetFinalScore returns the points of the final round, picking the truth pays
+// the wager on top of the tripled points and picking a lie loses it
+func GetFinalScore(players map[string]*Player, truthIdx int, rule string) map[string]int {
+	scoreMap := make(map[string]int)
+	for uid, p := range players {
+		if p.spectator {
+			continue
+		}
+		scoreMap[uid] = 0
+	}
+	for uid, player := range players {
+		if player.spectator || !player.ready {
+			continue // we don't count score for missed answer
+		}
+		if player.answerTruthId == truthIdx {
+			scoreMap[uid] += 1000*game.FinalMultiplier + player.wager
+			continue
+		}
+		scoreMap[uid] -= player.wager
+		authorIds := GetPlayerIdsByShuffledAnswerIdx(players, player.answerTruthId)
+		for _, lyingPlayerId := range authorIds {
+			scoreMap[lyingPlayerId] += GetFooledPoints(500*game.FinalMultiplier, len(authorIds), rule)
+		}
+	}
+	return scoreMap
+}
+
+// GetFinalReveals returns the order the final answers are revealed in,
+// the lies somebody picked go from the least to the most picked and the truth comes last
+func GetFinalReveals(players map[string]*Player, answers []string, truthIdx int) []*Reveal {
+	var uids []string
+	for uid := range players {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	var lies []*Reveal
+	var truth *Reveal
+	for idx, text := range answers {
+		reveal := &Reveal{Text: text, Truth: idx == truthIdx}
+		for _, uid := range uids {
+			p := players[uid]
+			if p.spectator {
+				continue
+			}
+			if p.shuffledAnswerIdx == idx {
+				reveal.AuthorIds = append(reveal.AuthorIds, uid)
+			}
+			if p.ready && p.answerTruthId == idx {
+				reveal.PickedIds = append(reveal.PickedIds, uid)
+			}
+		}
+		if reveal.Truth {
+			truth = reveal
+		} else if len(reveal.PickedIds) > 0 {
+			lies = append(lies, reveal)
+		}
+	}
+	sort.SliceStable(lies, func(i, j int) bool {
+		return len(lies[i].PickedIds) < len(lies[j].PickedIds)
+	})
+	if truth != nil {
+		lies = append(lies, truth)
+	}
+	return lies
+}
+
+// GetMaxWager returns the most a player can bet in the final round
+func GetMaxWager(totalScore int) int {
+	if totalScore < 0 {
+		return 0
+	}
+	return totalScore
+}
+
+// GetSeries returns the standing of every player across the games played in the room,
+// the game being played counts with its current score
+func GetSeries(players map[string]*Player) map[string]*SeriesRow {
+	series := make(map[string]*SeriesRow)
+	for uid, p := range players {
+		if p.spectator {
+			continue
+		}
+		series[uid] = &SeriesRow{
+			Score: p.seriesScore + p.totalScore,
+			Wins:  p.seriesWins,
+		}
+	}
+	return series
+}
+
+// GetMajority returns the number of votes needed out of the given voters
+func GetMajority(voters int) int {
+	return voters/2 + 1
+}
+
+// GetVotesNeeded returns the number of votes needed for the given percent of the voters
+func GetVotesNeeded(voters int, percent int) int {
+	needed := (voters*percent + 99) / 100
+	if needed < 1 {
+		return 1
+	}
+	return needed
+}
+
+// GetPlayerCount returns the number of seats taken in the room, spectators don't take one
+func GetPlayerCount(players map[string]*Player) int {
+	count := 0
+	for _, p := range players {
+		if !p.spectator {
+			count++
+		}
+	}
+	return count
+}
+
+// GetPlayerIds filters spectators out of the group members
+func GetPlayerIds(players map[string]*Player, members []string) []string {
+	var res []string
+	for _, uid := range members {
+		if p, ok := players[uid]; ok && !p.spectator {
+			res = append(res, uid)
+		}
+	}
+	return res
+}
+
 func ArePlayersReady(players map[string]*Player, members []string) bool {
 	for _, uid := range members {
-		if players[uid].ready {
+		p, ok := players[uid]
+		if !ok || p.spectator || p.current || p.ready {
 			continue
 		} else {
 			return false
@@ -136,3 +417,23 @@ func ArePlayersReady(players map[string]*Player, members []string) bool {
 	}
 	return true
 }
+
+// GetRequestedRoom returns the room code named in the payload of the requests that enter a room
+func GetRequestedRoom(method string, payload []byte) string {
+	switch strings.ToLower(method) {
+	case "join", "spectate", "rejoin":
+	default:
+		return ""
+	}
+	msg := &RejoinMessage{}
+	if err := json.Unmarshal(payload, msg); err != nil {
+		return ""
+	}
+	return NormalizeRoomCode(msg.GroupUuid)
+}
+
+// GetAboutQuestion fills a question template with the name of the player it asks about
+func GetAboutQuestion(template string, name string) string {
+	question := strings.Replace(template, game.PLAYER, name, -1)
+	return strings.Replace(question, game.BLANK, "______", -1)
+}
@@ -1,10 +1,15 @@
 package factsv2
 
 import (
+	"errors"
 	"fmt"
 	"github.com/bmizerany/assert"
 	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game"
+	"github.com/zdarovich/fibbage-game-server/internal/services/game/state"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetPlayersScore1(t *testing.T) {
@@ -18,14 +23,14 @@ func TestGetPlayersScore1(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -33,7 +38,7 @@ func TestGetPlayersScore1(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -57,14 +62,14 @@ func TestGetPlayersScore2(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -72,7 +77,7 @@ func TestGetPlayersScore2(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -97,14 +102,14 @@ func TestGetPlayersScore3(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -112,7 +117,7 @@ func TestGetPlayersScore3(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -137,14 +142,14 @@ func TestGetPlayersScore4(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -152,7 +157,7 @@ func TestGetPlayersScore4(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -177,14 +182,14 @@ func TestGetPlayersScore5(t *testing.T) {
 
 	players := make(map[string]*Player, 3)
 	players[currentPlayerId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer1",
 		},
 		answerLie: "answerLie1",
 		ready:     true,
 	}
 	players[playerTwoId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer2",
 		},
 		answerLie:     "answerLie2",
@@ -192,7 +197,7 @@ func TestGetPlayersScore5(t *testing.T) {
 		ready:         true,
 	}
 	players[playerThreeId] = &Player{
-		question: &models.Question{
+		question: &Question{
 			Answer: "truthAnswer3",
 		},
 		answerLie:     "answerLie3",
@@ -205,3 +210,293 @@ func TestGetPlayersScore5(t *testing.T) {
 
 	assert.Equal(t, expected, result)
 }
+
+func TestGenerateRoomCode(t *testing.T) {
+	code, err := GenerateRoomCode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, game.RoomCodeLength, len(code))
+	for _, c := range code {
+		assert.T(t, strings.ContainsRune(game.RoomCodeAlphabet, c), "unexpected rune in room code")
+	}
+}
+
+func TestNormalizeRoomCode(t *testing.T) {
+	assert.Equal(t, "ABCD", NormalizeRoomCode(" abcd "))
+}
+
+func TestGetLeaderId(t *testing.T) {
+	players := make(map[string]*Player, 3)
+	players["player1"] = &Player{totalScore: 500}
+	players["player2"] = &Player{totalScore: 1500}
+	players["player3"] = &Player{totalScore: 1000}
+
+	assert.Equal(t, "player2", GetLeaderId(players))
+	assert.Equal(t, "", GetLeaderId(map[string]*Player{}))
+
+	players["spectator"] = &Player{totalScore: 2000, spectator: true}
+	assert.Equal(t, "player2", GetLeaderId(players))
+	players["player3"].totalScore = 1500
+	assert.Equal(t, "", GetLeaderId(players))
+	assert.Equal(t, "", GetLeaderId(map[string]*Player{"player1": {}, "spectator": {spectator: true}}))
+}
+
+func TestGetStateType(t *testing.T) {
+	assert.Equal(t, models.WAIT, GetStateType(state.WAITING))
+	assert.Equal(t, models.ONE, GetStateType(state.STARTING))
+	assert.Equal(t, models.TWO, GetStateType(state.INPUT_LIE_TEXT))
+	assert.Equal(t, models.THREE, GetStateType(state.SCORE))
+}
+
+func TestGetNextHostId(t *testing.T) {
+	now := time.Now()
+	players := make(map[string]*Player, 4)
+	players["player1"] = &Player{host: true, connected: true, joinedAt: now}
+	players["player2"] = &Player{connected: true, joinedAt: now.Add(2 * time.Second)}
+	players["player3"] = &Player{connected: true, joinedAt: now.Add(time.Second)}
+	players["player4"] = &Player{connected: false, joinedAt: now.Add(-time.Second)}
+
+	assert.Equal(t, "player3", GetNextHostId(players, []string{"player2", "player3", "player4"}))
+	assert.Equal(t, "", GetNextHostId(players, []string{"player1"}))
+}
+
+func TestGetRemainingTicks(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 5, GetRemainingTicks(now.Add(4500*time.Millisecond), now))
+	assert.Equal(t, 0, GetRemainingTicks(now.Add(-time.Second), now))
+	assert.Equal(t, 0, GetRemainingTicks(time.Time{}, now))
+}
+
+func TestSpectatorsAreSkipped(t *testing.T) {
+	currentPlayerId := "player1"
+	players := make(map[string]*Player, 3)
+	players[currentPlayerId] = &Player{
+		question: &Question{
+			Answer:            "truthAnswer1",
+			ShuffledAnswerIdx: 0,
+		},
+		shuffledAnswerIdx: 1,
+		answerTruthId:     2,
+		ready:             true,
+	}
+	players["player2"] = &Player{
+		shuffledAnswerIdx: 2,
+		answerTruthId:     0,
+		ready:             true,
+	}
+	players["spectator"] = &Player{
+		spectator: true,
+	}
+	expected := map[string]int{
+		currentPlayerId: 0,
+		"player2":       1500,
+	}
+
+	assert.Equal(t, expected, GetPlayersScoreV2(players, currentPlayerId, 1, game.SHARE))
+	expected["player2"] = 3000
+	assert.Equal(t, expected, GetPlayersScoreV2(players, currentPlayerId, 2, game.SHARE))
+	assert.Equal(t, true, ArePlayersReady(players, []string{currentPlayerId, "player2", "spectator"}))
+	assert.Equal(t, []string{currentPlayerId, "player2"}, GetPlayerIds(players, []string{currentPlayerId, "spectator", "player2"}))
+}
+
+func TestGetPlayerCount(t *testing.T) {
+	players := map[string]*Player{
+		"1": {},
+		"2": {},
+		"3": {spectator: true},
+	}
+	assert.Equal(t, 2, GetPlayerCount(players))
+}
+
+func TestGetRoomExpiry(t *testing.T) {
+	createdAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiry := Expiry{Idle: 15 * time.Minute, Hard: time.Hour}
+
+	reason, closeAt := GetRoomExpiry(createdAt, createdAt.Add(10*time.Minute), expiry)
+	assert.Equal(t, game.IDLE, reason)
+	assert.Equal(t, createdAt.Add(25*time.Minute), closeAt)
+
+	reason, closeAt = GetRoomExpiry(createdAt, createdAt.Add(50*time.Minute), expiry)
+	assert.Equal(t, game.EXPIRED, reason)
+	assert.Equal(t, createdAt.Add(time.Hour), closeAt)
+}
+
+func TestGetSeries(t *testing.T) {
+	players := map[string]*Player{
+		"1": {seriesScore: 3000, seriesWins: 1, totalScore: 500},
+		"2": {seriesScore: 1000, totalScore: 1500},
+		"3": {spectator: true},
+	}
+	series := GetSeries(players)
+	assert.Equal(t, 2, len(series))
+	assert.Equal(t, &SeriesRow{Score: 3500, Wins: 1}, series["1"])
+	assert.Equal(t, &SeriesRow{Score: 2500, Wins: 0}, series["2"])
+}
+
+func TestGetMajority(t *testing.T) {
+	assert.Equal(t, 1, GetMajority(1))
+	assert.Equal(t, 2, GetMajority(2))
+	assert.Equal(t, 2, GetMajority(3))
+	assert.Equal(t, 3, GetMajority(4))
+}
+
+func TestValidateRoomSettings(t *testing.T) {
+	settings := DefaultRoomSettings()
+	assert.Equal(t, nil, ValidateRoomSettings(&settings))
+
+	settings.Input = 0
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+
+	settings = DefaultRoomSettings()
+	settings.Language = "xx"
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+
+	settings = DefaultRoomSettings()
+	settings.MinPlayers = 6
+	settings.MaxPlayers = 4
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+
+	settings = DefaultRoomSettings()
+	settings.Rounds = 0
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+
+	settings = DefaultRoomSettings()
+	settings.Plan = []Round{{Questions: 3}, {Questions: 3, Multiplier: 2}}
+	assert.Equal(t, nil, ValidateRoomSettings(&settings))
+	settings.Plan[1].Multiplier = game.MaxMultiplier + 1
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+	settings.Plan = make([]Round, game.MaxRounds+1)
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+
+	settings = DefaultRoomSettings()
+	settings.Fooled = game.SPLIT
+	assert.Equal(t, nil, ValidateRoomSettings(&settings))
+	settings.Fooled = "HALF"
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+
+	settings = DefaultRoomSettings()
+	settings.Mode = models.ABOUTYOU
+	assert.Equal(t, nil, ValidateRoomSettings(&settings))
+	settings.Mode = models.ABOUTYOU + 1
+	assert.NotEqual(t, nil, ValidateRoomSettings(&settings))
+}
+
+type noFinalsStore struct {
+	fakeQuestionStore
+}
+
+func (noFinalsStore) Finals(langCode string, familyFriendly bool) ([]models.Question, error) {
+	return nil, nil
+}
+
+func TestCheckContent(t *testing.T) {
+	settings := DefaultRoomSettings()
+	assert.Equal(t, nil, CheckContent(fakeQuestionStore{}, fakeTemplateStore{}, settings))
+	assert.Equal(t, true, errors.Is(CheckContent(noFinalsStore{}, fakeTemplateStore{}, settings), ErrNoContent))
+
+	settings.Final = false
+	assert.Equal(t, nil, CheckContent(noFinalsStore{}, fakeTemplateStore{}, settings))
+
+	settings.Rounds = 3 // 24 questions for 8 players
+	assert.Equal(t, true, errors.Is(CheckContent(fakeQuestionStore{}, fakeTemplateStore{}, settings), ErrNoContent))
+
+	settings = DefaultRoomSettings()
+	settings.Mode = models.ABOUTYOU
+	assert.Equal(t, nil, CheckContent(fakeQuestionStore{}, fakeTemplateStore{}, settings))
+	settings.Plan = []Round{{}, {}, {Questions: 5}} // 21 templates
+	assert.Equal(t, true, errors.Is(CheckContent(fakeQuestionStore{}, fakeTemplateStore{}, settings), ErrNoContent))
+}
+
+func TestGetRoundPlan(t *testing.T) {
+	settings := DefaultRoomSettings()
+	settings.Rounds = 2
+	assert.Equal(t, []Round{{Questions: 3, Multiplier: 1}, {Questions: 3, Multiplier: 2}}, GetRoundPlan(settings, 3))
+
+	settings.Plan = []Round{{Questions: 2}, {Multiplier: 3}}
+	assert.Equal(t, []Round{{Questions: 2, Multiplier: 1}, {Questions: 4, Multiplier: 3}}, GetRoundPlan(settings, 4))
+}
+
+func TestGetMillis(t *testing.T) {
+	assert.Equal(t, int64(1577880000123), GetMillis(time.Date(2020, 1, 1, 12, 0, 0, 123456789, time.UTC)))
+}
+
+func TestGetVotesNeeded(t *testing.T) {
+	assert.Equal(t, 2, GetVotesNeeded(3, 51))
+	assert.Equal(t, 3, GetVotesNeeded(4, 51))
+	assert.Equal(t, 4, GetVotesNeeded(4, 100))
+	assert.Equal(t, 1, GetVotesNeeded(4, 1))
+	assert.Equal(t, 1, GetVotesNeeded(0, 51))
+}
+
+func TestGetRequestedRoom(t *testing.T) {
+	assert.Equal(t, "ABCD", GetRequestedRoom("join", []byte(`{"nickname":"bob","uuid":" abcd "}`)))
+	assert.Equal(t, "ABCD", GetRequestedRoom("rejoin", []byte(`{"token":"t","uuid":"ABCD"}`)))
+	assert.Equal(t, "", GetRequestedRoom("input", []byte(`{"uuid":"ABCD"}`)))
+	assert.Equal(t, "", GetRequestedRoom("join", []byte(`not json`)))
+}
+
+func TestGetPlayersScoreSharedLie(t *testing.T) {
+	players := map[string]*Player{
+		"a": {question: &Question{Answer: "truth", ShuffledAnswerIdx: 0}, answerLie: "lie", shuffledAnswerIdx: 1, answerTruthId: 2, ready: true},
+		"b": {answerLie: "Lie!", shuffledAnswerIdx: 1, answerTruthId: 2, ready: true},
+		"c": {answerLie: "other", shuffledAnswerIdx: 2, answerTruthId: 1, ready: true},
+	}
+	assert.Equal(t, map[string]int{"a": 500, "b": 500, "c": 1000}, GetPlayersScoreV2(players, "a", 1, game.SHARE))
+	assert.Equal(t, map[string]int{"a": 250, "b": 250, "c": 1000}, GetPlayersScoreV2(players, "a", 1, game.SPLIT))
+
+	matrix := GetAnswersMatrix(players, "a")
+	assert.Equal(t, []string{"a", "b"}, matrix["a"].AuthorIds)
+	assert.Equal(t, []string{"a", "b"}, matrix["b"].AuthorIds)
+	assert.Equal(t, []string{"c"}, matrix["a"].PickedIds)
+	assert.Equal(t, []string{"c"}, matrix["c"].AuthorIds)
+}
+
+func TestGetFooledPoints(t *testing.T) {
+	assert.Equal(t, 500, GetFooledPoints(500, 3, game.SHARE))
+	assert.Equal(t, 166, GetFooledPoints(500, 3, game.SPLIT))
+	assert.Equal(t, 500, GetFooledPoints(500, 1, game.SPLIT))
+	assert.Equal(t, 500, GetFooledPoints(500, 2, ""))
+}
+
+func TestGetFinalScore(t *testing.T) {
+	players := map[string]*Player{
+		"a": {ready: true, answerTruthId: 0, shuffledAnswerIdx: 1, wager: 200},
+		"b": {ready: true, answerTruthId: 2, shuffledAnswerIdx: 3, wager: 100},
+		"c": {ready: true, answerTruthId: 1, shuffledAnswerIdx: 2},
+		"d": {ready: false, shuffledAnswerIdx: -1, wager: 50},
+		"e": {spectator: true, shuffledAnswerIdx: -1},
+	}
+	score := GetFinalScore(players, 0, game.SHARE)
+	assert.Equal(t, 3200+1500, score["a"])
+	assert.Equal(t, -100, score["b"])
+	assert.Equal(t, 1500, score["c"])
+	assert.Equal(t, 0, score["d"])
+	_, ok := score["e"]
+	assert.Equal(t, false, ok)
+}
+
+func TestGetFinalReveals(t *testing.T) {
+	players := map[string]*Player{
+		"a": {ready: true, answerTruthId: 0, shuffledAnswerIdx: 1},
+		"b": {ready: true, answerTruthId: 1, shuffledAnswerIdx: 2},
+		"c": {ready: true, answerTruthId: 2, shuffledAnswerIdx: 3},
+		"d": {ready: true, answerTruthId: 2, shuffledAnswerIdx: 4},
+	}
+	reveals := GetFinalReveals(players, []string{"truth", "a", "b", "c", "d"}, 0)
+	assert.Equal(t, 3, len(reveals))
+	assert.Equal(t, "a", reveals[0].Text)
+	assert.Equal(t, []string{"b"}, reveals[0].PickedIds)
+	assert.Equal(t, "b", reveals[1].Text)
+	assert.Equal(t, []string{"b"}, reveals[1].AuthorIds)
+	assert.Equal(t, []string{"c", "d"}, reveals[1].PickedIds)
+	assert.Equal(t, true, reveals[2].Truth)
+	assert.Equal(t, []string{"a"}, reveals[2].PickedIds)
+}
+
+func TestGetMaxWager(t *testing.T) {
+	assert.Equal(t, 1500, GetMaxWager(1500))
+	assert.Equal(t, 0, GetMaxWager(-500))
+}
+
+func TestGetAboutQuestion(t *testing.T) {
+	assert.Equal(t, "Bob once ate ______.", GetAboutQuestion("<PLAYER> once ate <BLANK>.", "Bob"))
+}
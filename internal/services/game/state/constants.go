@@ -10,7 +10,17 @@ const (
 	INPUT_CATEGORY    string = "INPUT_CATEGORY"
 	SHOWING_CHOICE    string = "SHOWING_CHOICE"
 	INPUT_LIE_TEXT    string = "INPUT_LIE_TEXT"
+	INPUT_TRUTH       string = "INPUT_TRUTH"
 	INPUT_TRUE_OPTION string = "INPUT_TRUE_OPTION"
 	FINISH            string = "FINISH"
+	ROUND             string = "ROUND"
 	RESET             string = "RESET"
+	ABORTED           string = "ABORTED"
+	// the final round asks everybody the same question and reveals the answers one by one
+	FINAL                string = "FINAL"
+	INPUT_FINAL_LIE_TEXT string = "INPUT_FINAL_LIE_TEXT"
+	FINAL_THREE          string = "FINAL_THREE"
+	INPUT_FINAL_OPTION   string = "INPUT_FINAL_OPTION"
+	FINAL_REVEAL         string = "FINAL_REVEAL"
+	FINAL_SCORE          string = "FINAL_SCORE"
 )
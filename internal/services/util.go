@@ -1,6 +1,10 @@
 package services
 
-import "github.com/zdarovich/fibbage-game-server/internal/db/models"
+import (
+	"github.com/zdarovich/fibbage-game-server/internal/db/models"
+	"strings"
+	"unicode"
+)
 
 func Remove(s []string, i int) []string {
 	s[len(s)-1], s[i] = s[i], s[len(s)-1]
@@ -11,3 +15,99 @@ func RemoveQuestions(s []models.Question, i int) []models.Question {
 	s[len(s)-1], s[i] = s[i], s[len(s)-1]
 	return s[:len(s)-1]
 }
+
+// articles are dropped when answers are compared
+var articles = map[string]bool{
+	"a":   true,
+	"an":  true,
+	"the": true,
+}
+
+// SplitAlternateSpellings returns the spellings of an answer stored comma-joined in the questions table
+func SplitAlternateSpellings(s string) []string {
+	var res []string
+	for _, spelling := range strings.Split(s, ",") {
+		if spelling = strings.TrimSpace(spelling); spelling != "" {
+			res = append(res, spelling)
+		}
+	}
+	return res
+}
+
+// NormalizeAnswer lowercases the answer and drops its punctuation, articles and extra whitespace
+func NormalizeAnswer(s string) string {
+	s = strings.ToLower(s)
+	s = strings.Replace(s, "ё", "е", -1)
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	var words []string
+	for _, word := range strings.Fields(s) {
+		if !articles[word] {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// IsTruth reports whether the lie is the answer or one of its spellings, allowing for a typo or two
+func IsTruth(lie string, answer string, alternates []string) bool {
+	normalized := NormalizeAnswer(lie)
+	if normalized == "" {
+		return false
+	}
+	for _, truth := range append([]string{answer}, alternates...) {
+		truth = NormalizeAnswer(truth)
+		if truth == "" {
+			continue
+		}
+		if GetEditDistance(normalized, truth) <= maxTypos(truth) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTypos returns the edit distance still counted as the same answer, short answers must match exactly
+func maxTypos(answer string) int {
+	n := len([]rune(answer))
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// GetEditDistance returns the Levenshtein distance between two strings
+func GetEditDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestSplitAlternateSpellings(t *testing.T) {
+	assert.Equal(t, []string{"grey", "gray"}, SplitAlternateSpellings("grey, gray,"))
+	assert.Equal(t, 0, len(SplitAlternateSpellings("")))
+}
+
+func TestNormalizeAnswer(t *testing.T) {
+	assert.Equal(t, "eiffel tower", NormalizeAnswer("  The Eiffel-Tower! "))
+	assert.Equal(t, "елка", NormalizeAnswer("Ёлка"))
+	assert.Equal(t, "", NormalizeAnswer("a"))
+}
+
+func TestIsTruth(t *testing.T) {
+	assert.Equal(t, true, IsTruth("the eiffel tower", "Eiffel Tower", nil))
+	assert.Equal(t, true, IsTruth("eifel tower", "Eiffel Tower", nil))
+	assert.Equal(t, true, IsTruth("Gray", "grey", []string{"gray"}))
+	assert.Equal(t, false, IsTruth("cat", "car", nil))
+	assert.Equal(t, false, IsTruth("big ben", "Eiffel Tower", nil))
+	assert.Equal(t, false, IsTruth("!!!", "Eiffel Tower", nil))
+}
+
+func TestGetEditDistance(t *testing.T) {
+	assert.Equal(t, 3, GetEditDistance("kitten", "sitting"))
+	assert.Equal(t, 0, GetEditDistance("", ""))
+	assert.Equal(t, 4, GetEditDistance("", "кошк"))
+}